@@ -0,0 +1,158 @@
+package jsonds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+)
+
+// AccessLogFormat identifies the format of the access log.
+type AccessLogFormat string
+
+// Available AccessLogFormats:
+const (
+	AccessLogCommon   AccessLogFormat = `common`
+	AccessLogCombined AccessLogFormat = `combined`
+	AccessLogJSON     AccessLogFormat = `json`
+)
+
+// Grafana headers sent by the datasource proxy:
+const (
+	HeaderGrafanaUser  = `X-Grafana-User`
+	HeaderGrafanaOrgID = `X-Grafana-Org-Id`
+)
+
+// AccessLog logs a line per request in the common or combined log format to a Writer,
+// or as structured JSON through the GrafanaBackend zap logger.
+// Common and combined lines are followed by the duration in milliseconds, the Grafana user and org,
+// the requested targets and the time range.
+// SampleRate is the fraction of successful requests logged, zero logging all of them;
+// failed requests are always logged.
+type AccessLog struct {
+	Format     AccessLogFormat
+	SampleRate float64
+
+	out    io.Writer
+	logger *zap.Logger
+	lock   sync.Mutex
+}
+
+// accessInfo holds details of the decoded request, filled in while serving it.
+type accessInfo struct {
+	targets []string
+	rng     *Range
+}
+
+type accessInfoKey struct{}
+
+// NewAccessLog returns a new AccessLog writing to w. The Writer is not used for the JSON format.
+// The sampleRate must be between 0 and 1, 0 logging all requests.
+func NewAccessLog(format AccessLogFormat, w io.Writer, sampleRate float64) (*AccessLog, error) {
+	switch format {
+	case AccessLogCommon, AccessLogCombined:
+		if w == nil {
+			return nil, fmt.Errorf("access log format %v requires a writer", format)
+		}
+	case AccessLogJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format: %v", format)
+	}
+	if sampleRate < 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("access log sample rate must be between 0 and 1: %v", sampleRate)
+	}
+	return &AccessLog{
+		Format:     format,
+		SampleRate: sampleRate,
+		out:        w,
+		logger:     zap.NewNop(),
+	}, nil
+}
+
+// SetLogger sets the logger used for the JSON format.
+func (a *AccessLog) SetLogger(logger *zap.Logger) {
+	a.logger = logger
+}
+
+// Wrap returns a handle logging each request to the given handle.
+func (a *AccessLog) Wrap(ep Endpoint, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		start := time.Now()
+		info := &accessInfo{}
+		rw := newResponseWriter(w, 0)
+		handle(rw, r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info)), p)
+		if rw.Status() < http.StatusInternalServerError && a.SampleRate > 0 && a.SampleRate < 1 && rand.Float64() >= a.SampleRate {
+			return
+		}
+		a.log(ep, r, rw, info, start, time.Since(start))
+	}
+}
+
+func (a *AccessLog) log(ep Endpoint, r *http.Request, rw *responseWriter, info *accessInfo, start time.Time, duration time.Duration) {
+	user := r.Header.Get(HeaderGrafanaUser)
+	org := r.Header.Get(HeaderGrafanaOrgID)
+	var from, to string
+	if info.rng != nil {
+		from, to = info.rng.From.Format(time.RFC3339), info.rng.To.Format(time.RFC3339)
+	}
+	if a.Format == AccessLogJSON {
+		a.logger.Info("access",
+			zap.String("endpoint", string(ep)),
+			zap.String("method", r.Method),
+			zap.String("from", r.RemoteAddr),
+			zap.String("URI", r.RequestURI),
+			zap.Int("status", rw.Status()),
+			zap.Int("bytes", rw.bytes),
+			zap.Duration("duration", duration),
+			zap.String("grafana user", user),
+			zap.String("grafana org", org),
+			zap.Strings("targets", info.targets),
+			zap.String("range from", from),
+			zap.String("range to", to),
+		)
+		return
+	}
+	authUser := `-`
+	if u, _, ok := r.BasicAuth(); ok && u != `` {
+		authUser = u
+	}
+	host := r.RemoteAddr
+	if i := strings.LastIndex(host, `:`); i > 0 {
+		host = host[:i]
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %d",
+		host, authUser, start.Format(`02/Jan/2006:15:04:05 -0700`), r.Method, r.RequestURI, r.Proto, rw.Status(), rw.bytes)
+	if a.Format == AccessLogCombined {
+		line += fmt.Sprintf(" %q %q", r.Referer(), r.UserAgent())
+	}
+	line += fmt.Sprintf(" %.3f %q %q %q %q\n",
+		float64(duration)/float64(time.Millisecond), user, org, strings.Join(info.targets, `,`), from+`/`+to)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	io.WriteString(a.out, line)
+}
+
+// setAccessInfo records the targets and time range of the decoded Request for the access log, if enabled.
+func setAccessInfo(ctx context.Context, req Request) {
+	info, ok := ctx.Value(accessInfoKey{}).(*accessInfo)
+	if !ok {
+		return
+	}
+	switch req.ReqType() {
+	case ReqQuery:
+		info.targets = req.Query().ListTargets()
+		info.rng = &req.Query().Range
+	case ReqSearch:
+		info.targets = []string{req.Search().Target}
+	case ReqAnnotation:
+		info.targets = []string{req.Anno().Annotation.Name}
+		info.rng = &req.Anno().Range
+	}
+}
@@ -0,0 +1,121 @@
+package jsonds
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func accessLogHandle(status int) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		setAccessInfo(r.Context(), &QueryRequest{
+			Range:   Range{From: time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC), To: time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC)},
+			Targets: []Target{{Target: `a`}, {Target: `b`}},
+		})
+		w.WriteHeader(status)
+		w.Write([]byte(`hello`))
+	}
+}
+
+func accessLogRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, string(QueryEndpoint), nil)
+	r.RemoteAddr = `10.0.0.1:5000`
+	r.SetBasicAuth(`alice`, `secret`)
+	r.Header.Set(HeaderGrafanaUser, `bob`)
+	r.Header.Set(HeaderGrafanaOrgID, `1`)
+	r.Header.Set(`User-Agent`, `Grafana`)
+	return r
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	tests := []struct {
+		format AccessLogFormat
+		want   []string
+	}{
+		{AccessLogCommon, []string{
+			`10.0.0.1 - alice [`, `] "POST /query HTTP/1.1" 200 5 `,
+			`"bob" "1" "a,b" "2026-01-01T10:00:00Z/2026-01-01T11:00:00Z"`,
+		}},
+		{AccessLogCombined, []string{`"POST /query HTTP/1.1" 200 5 "" "Grafana" `, `"bob" "1" "a,b"`}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		a, err := NewAccessLog(tt.format, &buf, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Wrap(QueryEndpoint, accessLogHandle(http.StatusOK))(httptest.NewRecorder(), accessLogRequest(), nil)
+		line := buf.String()
+		for _, want := range tt.want {
+			if !strings.Contains(line, want) {
+				t.Errorf("%v line %q, want it to contain %q", tt.format, line, want)
+			}
+		}
+	}
+
+	core, logs := observer.New(zap.InfoLevel)
+	a, err := NewAccessLog(AccessLogJSON, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetLogger(zap.New(core))
+	a.Wrap(QueryEndpoint, accessLogHandle(http.StatusOK))(httptest.NewRecorder(), accessLogRequest(), nil)
+	if logs.Len() != 1 {
+		t.Fatalf("%d JSON access log entries, want 1", logs.Len())
+	}
+	fields := logs.All()[0].ContextMap()
+	want := map[string]interface{}{
+		`endpoint`:     string(QueryEndpoint),
+		`status`:       int64(http.StatusOK),
+		`bytes`:        int64(5),
+		`grafana user`: `bob`,
+		`grafana org`:  `1`,
+		`range from`:   `2026-01-01T10:00:00Z`,
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("field %v = %v, want %v", k, fields[k], v)
+		}
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate float64
+		status     int
+		want       int
+	}{
+		{`zero rate logs everything`, 0, http.StatusOK, 10},
+		{`full rate logs everything`, 1, http.StatusOK, 10},
+		{`low rate skips successes`, 1e-9, http.StatusOK, 0},
+		{`low rate keeps client errors sampled`, 1e-9, http.StatusBadRequest, 0},
+		{`server errors are always logged`, 1e-9, http.StatusInternalServerError, 10},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		a, err := NewAccessLog(AccessLogCommon, &buf, tt.sampleRate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handle := a.Wrap(QueryEndpoint, accessLogHandle(tt.status))
+		for i := 0; i < 10; i++ {
+			handle(httptest.NewRecorder(), accessLogRequest(), nil)
+		}
+		if got := strings.Count(buf.String(), "\n"); got != tt.want {
+			t.Errorf("%v: %d lines logged, want %d", tt.name, got, tt.want)
+		}
+	}
+	for _, rate := range []float64{-0.1, 1.5} {
+		if _, err := NewAccessLog(AccessLogCommon, &bytes.Buffer{}, rate); err == nil {
+			t.Errorf("NewAccessLog with sample rate %v succeeded, want an error", rate)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package jsonds
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 )
@@ -9,6 +10,8 @@ import (
 type AnnotationsReq struct {
 	Range      Range      `json:"range"`
 	Annotation Annotation `json:"annotation"`
+
+	ctx context.Context
 }
 
 // Range specifies the time range the request is valid for.
@@ -53,9 +56,25 @@ func (r AnnotationResponse) RespType() ResponseType {
 	return RespAnnotation
 }
 
-// MarshalJSON provides JSON marshalling for a TimeSeriesResponse.
+// MarshalJSON provides JSON marshalling for an AnnotationResponse.
 func (r AnnotationResponse) MarshalJSON() ([]byte, error) {
-	return json.Marshal(r)
+	type annotation AnnotationResponse
+	return json.Marshal(annotation(r))
+}
+
+// AnnotationsResponse contains multiple annotation events.
+type AnnotationsResponse struct {
+	Data []AnnotationResponse
+}
+
+// RespType satisfies the QueryResponse interface and returns the response type.
+func (r AnnotationsResponse) RespType() ResponseType {
+	return RespAnnotation
+}
+
+// MarshalJSON provides JSON marshalling for an AnnotationsResponse.
+func (r AnnotationsResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Data)
 }
 
 // AnnotationQuery is a collection of possible filters for a Grafana annotation
@@ -101,3 +120,11 @@ func (r *AnnotationsReq) TagKeys() *TagKeysReq {
 func (r *AnnotationsReq) TagValues() *TagValuesReq {
 	return nil
 }
+
+// Context returns the context set using WithContext, or the background context.
+func (r *AnnotationsReq) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
@@ -0,0 +1,58 @@
+package jsonds
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// BasicAuth requires requests to carry HTTP basic auth credentials matching one of its users.
+// Users can be replaced while serving requests.
+type BasicAuth struct {
+	users map[string]string
+	lock  sync.RWMutex
+}
+
+// NewBasicAuth returns a new BasicAuth for the given usernames and passwords.
+func NewBasicAuth(users map[string]string) *BasicAuth {
+	a := &BasicAuth{}
+	a.SetUsers(users)
+	return a
+}
+
+// SetUsers replaces the usernames and passwords accepted.
+func (a *BasicAuth) SetUsers(users map[string]string) {
+	copied := make(map[string]string, len(users))
+	for u, p := range users {
+		copied[u] = p
+	}
+	a.lock.Lock()
+	a.users = copied
+	a.lock.Unlock()
+}
+
+// Authenticate returns true if the request carries valid credentials.
+func (a *BasicAuth) Authenticate(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	a.lock.RLock()
+	expected, found := a.users[user]
+	a.lock.RUnlock()
+	return found && subtle.ConstantTimeCompare([]byte(pass), []byte(expected)) == 1
+}
+
+// Wrap returns a handle rejecting unauthenticated requests with a 401 response.
+func (a *BasicAuth) Wrap(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if !a.Authenticate(r) {
+			w.Header().Set(`WWW-Authenticate`, `Basic realm="`+applicationName+`"`)
+			writeJSONError(w, http.StatusUnauthorized, `unauthorized`)
+			return
+		}
+		handle(w, r, p)
+	}
+}
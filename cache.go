@@ -0,0 +1,242 @@
+package jsonds
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ResponseCache caches BEHandler Responses keyed by normalized Requests.
+// Entries expire after the TTL and the least recently used entries are evicted
+// once MaxEntries or MaxBytes is exceeded. A zero limit disables that limit.
+// Expired entries are swept on Set at most once per TTL, bounding an unlimited cache to the entries
+// set within about two TTLs.
+type ResponseCache struct {
+	TTL        time.Duration
+	MaxEntries int
+	MaxBytes   int
+
+	logger *zap.Logger
+	ll     *list.List
+	items  map[string]*list.Element
+	size   int
+	swept  time.Time
+	lock   sync.Mutex
+}
+
+type cacheEntry struct {
+	key     string
+	resp    Response
+	size    int
+	expires time.Time
+}
+
+// NewResponseCache returns a new ResponseCache.
+func NewResponseCache(ttl time.Duration, maxEntries, maxBytes int) *ResponseCache {
+	return &ResponseCache{
+		TTL:        ttl,
+		MaxEntries: maxEntries,
+		MaxBytes:   maxBytes,
+		logger:     zap.NewNop(),
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// SetLogger sets the logger used for cache hits and misses.
+func (c *ResponseCache) SetLogger(logger *zap.Logger) {
+	c.logger = logger
+}
+
+// Get returns a copy of the cached Response for the given key, if present and not expired.
+func (c *ResponseCache) Get(key string) (Response, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return copyResponse(entry.resp), true
+}
+
+// Set stores a copy of the Response under the given key, evicting entries as needed.
+func (c *ResponseCache) Set(key string, resp Response) {
+	var size int
+	if c.MaxBytes > 0 {
+		b, err := resp.MarshalJSON()
+		if err != nil {
+			return
+		}
+		size = len(b)
+		if size > c.MaxBytes {
+			return
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if now.Sub(c.swept) >= c.TTL {
+		c.sweep(now)
+	}
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	entry := &cacheEntry{
+		key:     key,
+		resp:    copyResponse(resp),
+		size:    size,
+		expires: now.Add(c.TTL),
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	c.size += size
+	for (c.MaxEntries > 0 && c.ll.Len() > c.MaxEntries) || (c.MaxBytes > 0 && c.size > c.MaxBytes) {
+		oldest := c.ll.Back()
+		c.logger.Debug("cache eviction", zap.String("key", oldest.Value.(*cacheEntry).key))
+		c.remove(oldest)
+	}
+}
+
+// Len returns the number of cached entries.
+func (c *ResponseCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ll.Len()
+}
+
+// Purge removes all cached entries.
+func (c *ResponseCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.size = 0
+}
+
+// sweep removes all expired entries.
+func (c *ResponseCache) sweep(now time.Time) {
+	for el := c.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if now.After(el.Value.(*cacheEntry).expires) {
+			c.remove(el)
+		}
+		el = prev
+	}
+	c.swept = now
+}
+
+func (c *ResponseCache) remove(el *list.Element) {
+	entry := c.ll.Remove(el).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+}
+
+// Wrap returns a BEHandler serving Responses from the cache, calling the given handler on a miss.
+// Errors and invalid Responses are not cached.
+func (c *ResponseCache) Wrap(ep Endpoint, handler BEHandler) BEHandler {
+	return func(req Request) (Response, error) {
+		key, err := RequestKey(ep, req)
+		if err != nil {
+			c.logger.Warn("cache key failure", zap.String("endpoint", string(ep)), zap.Error(err))
+			return handler(req)
+		}
+		if resp, ok := c.Get(key); ok {
+			c.logger.Debug("cache hit", zap.String("endpoint", string(ep)), zap.String("key", key))
+			return resp, nil
+		}
+		c.logger.Debug("cache miss", zap.String("endpoint", string(ep)), zap.String("key", key))
+		resp, err := handler(req)
+		if err == nil && resp != nil && resp.RespType() != RespInvalid {
+			c.Set(key, resp)
+		}
+		return resp, err
+	}
+}
+
+// RequestKey returns a normalized hash identifying the given Request for the Endpoint.
+// For QueryRequests the Range is rounded down to the request interval so that
+// refreshes within the same interval share a key.
+func RequestKey(ep Endpoint, req Request) (string, error) {
+	var body interface{}
+	switch req.ReqType() {
+	case ReqQuery:
+		q := req.Query()
+		interval := time.Duration(q.InvervalMS) * time.Millisecond
+		body = struct {
+			Range         Range         `json:"range"`
+			Interval      string        `json:"interval"`
+			IntervalMS    int64         `json:"intervalMs"`
+			Targets       []Target      `json:"targets"`
+			AdhocFilters  []AdhocFilter `json:"adhocFilters"`
+			Format        string        `json:"format"`
+			MaxDataPoints int           `json:"maxDataPoints"`
+			ScopedVars    ScopedVar     `json:"scopedVars"`
+		}{
+			Range: Range{
+				From: q.Range.From.Truncate(interval).UTC(),
+				To:   q.Range.To.Truncate(interval).UTC(),
+			},
+			Interval:      q.Interval,
+			IntervalMS:    q.InvervalMS,
+			Targets:       q.Targets,
+			AdhocFilters:  q.AdhocFilters,
+			Format:        q.Format,
+			MaxDataPoints: q.MaxDataPoints,
+			ScopedVars:    q.ScopedVars,
+		}
+	default:
+		body = req
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return ``, err
+	}
+	sum := sha256.Sum256(append([]byte(string(ep)+"\n"+string(req.ReqType())+"\n"), b...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// copyResponse returns a copy of the Response data, so that cached Responses
+// are not modified by handlers further down the chain.
+func copyResponse(resp Response) Response {
+	switch r := resp.(type) {
+	case TimeSeriesResponse:
+		data := make([]TimeSeriesData, len(r.Data))
+		for i, d := range r.Data {
+			d.Datapoints = append([]Datapoint(nil), d.Datapoints...)
+			d.Labels = d.Labels.Copy()
+			data[i] = d
+		}
+		return TimeSeriesResponse{Data: data}
+	case TableResponse:
+		data := make([]TableData, len(r.Data))
+		for i, d := range r.Data {
+			d.Columns = append([]TagKey(nil), d.Columns...)
+			rows := make([][]interface{}, len(d.Rows))
+			for j, row := range d.Rows {
+				rows[j] = append([]interface{}(nil), row...)
+			}
+			d.Rows = rows
+			data[i] = d
+		}
+		return TableResponse{Data: data}
+	case AnnotationsResponse:
+		return AnnotationsResponse{Data: append([]AnnotationResponse(nil), r.Data...)}
+	case TagKeysResp:
+		return TagKeysResp{Data: append([]TagKey(nil), r.Data...)}
+	case TagValuesResp:
+		return TagValuesResp{Data: append([]TagValue(nil), r.Data...)}
+	case SearchResponse:
+		return SearchResponse{Data: append([]string(nil), r.Data...), Values: append([]SearchValue(nil), r.Values...)}
+	}
+	return resp
+}
@@ -0,0 +1,105 @@
+package jsonds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestKey(t *testing.T) {
+	from := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	query := func(from time.Time, intervalMS int64) *QueryRequest {
+		return &QueryRequest{
+			Range:      Range{From: from, To: from.Add(time.Hour)},
+			Interval:   (time.Duration(intervalMS) * time.Millisecond).String(),
+			InvervalMS: intervalMS,
+			Targets:    []Target{{Target: `a`}},
+		}
+	}
+	key := func(q *QueryRequest) string {
+		k, err := RequestKey(QueryEndpoint, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	base := key(query(from, 60000))
+	if got := key(query(from.Add(10*time.Second), 60000)); got != base {
+		t.Error("refresh within the same interval changed the key")
+	}
+	if got := key(query(from.Add(time.Minute), 60000)); got == base {
+		t.Error("refresh in the next interval kept the key")
+	}
+	if got := key(query(from, 30000)); got == base {
+		t.Error("different interval kept the key")
+	}
+}
+
+func TestResponseCacheWrap(t *testing.T) {
+	c := NewResponseCache(time.Minute, 0, 0)
+	var calls int
+	handler := c.Wrap(QueryEndpoint, func(Request) (Response, error) {
+		calls++
+		return TimeSeriesResponse{Data: []TimeSeriesData{
+			{Target: `a`, Labels: Labels{`host`: `h1`}, Datapoints: []Datapoint{{1, 1000}}},
+		}}, nil
+	})
+	q := &QueryRequest{Targets: []Target{{Target: `a`}}}
+	for i := 0; i < 3; i++ {
+		resp, err := handler(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := resp.(TimeSeriesResponse)
+		if ts.Data[0].Datapoints[0].MetricValue != 1 || ts.Data[0].Labels[`host`] != `h1` {
+			t.Fatalf("call %d: cached response was modified: %+v", i, ts.Data[0])
+		}
+		ts.Data[0].Datapoints[0].MetricValue = 2
+		ts.Data[0].Labels[`host`] = `h2`
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	c := NewResponseCache(time.Minute, 2, 0)
+	c.Set(`a`, TagKeysResp{})
+	c.Set(`b`, TagKeysResp{})
+	c.Get(`a`)
+	c.Set(`c`, TagKeysResp{})
+	if _, ok := c.Get(`b`); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if _, ok := c.Get(`a`); !ok {
+		t.Error("recently used entry was evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+}
+
+func TestResponseCacheExpiry(t *testing.T) {
+	c := NewResponseCache(-time.Second, 0, 0)
+	c.Set(`a`, TagKeysResp{})
+	if _, ok := c.Get(`a`); ok {
+		t.Error("expired entry was returned")
+	}
+}
+
+func TestResponseCacheSweep(t *testing.T) {
+	c := NewResponseCache(20*time.Millisecond, 0, 0)
+	for _, key := range []string{`a`, `b`, `c`} {
+		c.Set(key, SearchResponse{Data: []string{key}})
+	}
+	if c.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", c.Len())
+	}
+	time.Sleep(30 * time.Millisecond)
+	c.Set(`d`, SearchResponse{Data: []string{`d`}})
+	if c.Len() != 1 {
+		t.Errorf("Len = %d, want the expired entries swept", c.Len())
+	}
+	if _, ok := c.Get(`d`); !ok {
+		t.Error("entry set after the sweep missing")
+	}
+}
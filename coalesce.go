@@ -0,0 +1,103 @@
+package jsonds
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Coalescer deduplicates concurrent identical Requests so that they share a single BEHandler invocation.
+// Requests are identical when they share the same RequestKey.
+type Coalescer struct {
+	logger *zap.Logger
+	calls  map[string]*inflightCall
+	lock   sync.Mutex
+}
+
+type inflightCall struct {
+	done    chan struct{}
+	resp    Response
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// NewCoalescer returns a new Coalescer.
+func NewCoalescer() *Coalescer {
+	return &Coalescer{
+		logger: zap.NewNop(),
+		calls:  make(map[string]*inflightCall),
+	}
+}
+
+// SetLogger sets the logger used for coalesced requests.
+func (c *Coalescer) SetLogger(logger *zap.Logger) {
+	c.logger = logger
+}
+
+// Inflight returns the number of BEHandler invocations currently in flight.
+func (c *Coalescer) Inflight() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.calls)
+}
+
+// Wrap returns a BEHandler sharing in-flight invocations of the given handler between identical Requests.
+// The shared invocation carries the values of the first Request's context, such as its trace span,
+// and is only cancelled once every waiting Request's context is done.
+func (c *Coalescer) Wrap(ep Endpoint, handler BEHandler) BEHandler {
+	return func(req Request) (Response, error) {
+		key, err := RequestKey(ep, req)
+		if err != nil {
+			return handler(req)
+		}
+		c.lock.Lock()
+		call, ok := c.calls[key]
+		if ok {
+			call.waiters++
+			c.lock.Unlock()
+			c.logger.Debug("coalesced request", zap.String("endpoint", string(ep)), zap.String("key", key))
+		} else {
+			ctx, cancel := context.WithCancel(context.WithoutCancel(RequestContext(req)))
+			call = &inflightCall{
+				done:    make(chan struct{}),
+				waiters: 1,
+				cancel:  cancel,
+			}
+			c.calls[key] = call
+			c.lock.Unlock()
+			go c.run(key, call, handler, WithContext(req, ctx))
+		}
+		select {
+		case <-call.done:
+			return call.resp, call.err
+		case <-RequestContext(req).Done():
+			c.leave(key, call)
+			return InvalidData{}, RequestContext(req).Err()
+		}
+	}
+}
+
+func (c *Coalescer) run(key string, call *inflightCall, handler BEHandler, req Request) {
+	defer call.cancel()
+	call.resp, call.err = handler(req)
+	c.lock.Lock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	c.lock.Unlock()
+	close(call.done)
+}
+
+func (c *Coalescer) leave(key string, call *inflightCall) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	call.waiters--
+	if call.waiters == 0 {
+		if c.calls[key] == call {
+			delete(c.calls, key)
+		}
+		call.cancel()
+	}
+}
@@ -0,0 +1,89 @@
+package jsonds
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+func TestWithContext(t *testing.T) {
+	q := &QueryRequest{Targets: []Target{{Target: `a`}}}
+	ctx := context.WithValue(context.Background(), ctxKey{}, `v`)
+	req := WithContext(q, ctx)
+	sub, ok := req.(*QueryRequest)
+	if !ok {
+		t.Fatalf("WithContext returned %T, want *QueryRequest", req)
+	}
+	if RequestContext(sub).Value(ctxKey{}) != `v` {
+		t.Error("context not carried by the returned Request")
+	}
+	if RequestContext(q).Value(ctxKey{}) != nil {
+		t.Error("context set on the original Request")
+	}
+	copied := *sub
+	if RequestContext(&copied).Value(ctxKey{}) != `v` {
+		t.Error("context not carried by a copy of the Request")
+	}
+	var keys TagKeysReq
+	if RequestContext(WithContext(&keys, ctx)) != context.Background() {
+		t.Error("TagKeysReq carried a context")
+	}
+}
+
+func TestCoalescer(t *testing.T) {
+	c := NewCoalescer()
+	release := make(chan struct{})
+	var calls int32
+	handler := c.Wrap(QueryEndpoint, func(req Request) (Response, error) {
+		atomic.AddInt32(&calls, 1)
+		if RequestContext(req).Value(ctxKey{}) == nil {
+			t.Error("shared invocation lost the context values")
+		}
+		<-release
+		return TimeSeriesResponse{}, nil
+	})
+	ctx := context.WithValue(context.Background(), ctxKey{}, `v`)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := handler(WithContext(&QueryRequest{}, ctx)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for c.Inflight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("handler called %d times, want 1", n)
+	}
+}
+
+func TestCoalescerCancel(t *testing.T) {
+	c := NewCoalescer()
+	cancelled := make(chan struct{})
+	handler := c.Wrap(QueryEndpoint, func(req Request) (Response, error) {
+		<-RequestContext(req).Done()
+		close(cancelled)
+		return InvalidData{}, RequestContext(req).Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	if _, err := handler(WithContext(&QueryRequest{}, ctx)); err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("shared invocation not cancelled once its only Request was cancelled")
+	}
+}
@@ -1,9 +1,17 @@
 package jsonds
 
 import (
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
+	"go.uber.org/zap/zapcore"
 )
 
 // Config holds the configuration.
@@ -11,19 +19,302 @@ type Config struct {
 	Name        string
 	LogLevel    string
 	HTTPAddress string
+	Endpoints   map[string]EndpointConfig
+	Cache       CacheConfig
+	Auth        AuthConfig
+	Limits      LimitsConfig
+	Data        map[string]interface{}
+}
+
+// EndpointConfig declares the path, BEHandlers and timeout of an Endpoint.
+// Endpoints are configured by name: root, search, query, annotation, tagkeys and tagvalues.
+// Handler names refer to BEHandlers added with RegisterHandler.
+type EndpointConfig struct {
+	Path    string
+	Handler string
+	Targets []TargetRouteConfig
+	Timeout time.Duration
+}
+
+// TargetRouteConfig routes targets matching the regular expression to the named BEHandler.
+type TargetRouteConfig struct {
+	Match   string
+	Handler string
+}
+
+// CacheConfig configures the ResponseCache.
+type CacheConfig struct {
+	Enabled    bool
+	TTL        time.Duration
+	MaxEntries int
+	MaxBytes   int
+}
+
+// AuthConfig configures HTTP basic auth. Auth is disabled without any Users.
+type AuthConfig struct {
+	Users []UserConfig
+}
+
+// LimitsConfig configures the Limiter. Limits are disabled when no rate or MaxConcurrent is set.
+type LimitsConfig struct {
+	PerClient         RateLimit
+	PerIdentity       RateLimit
+	PerEndpoint       RateLimit
+	MaxConcurrent     int
+	TrustForwardedFor bool
+}
+
+// enabled returns true if any limit is set.
+func (l LimitsConfig) enabled() bool {
+	return l.PerClient.Rate > 0 || l.PerIdentity.Rate > 0 || l.PerEndpoint.Rate > 0 || l.MaxConcurrent > 0
+}
+
+// UserConfig holds the credentials of a user.
+type UserConfig struct {
+	Username string
+	Password string
+}
+
+// endpointNames maps the names used in the config file to the Endpoints.
+var endpointNames = map[string]*Endpoint{
+	`root`:                &RootEndpoint,
+	string(ReqSearch):     &SearchEndpoint,
+	string(ReqQuery):      &QueryEndpoint,
+	string(ReqAnnotation): &AnnotationsEndpoint,
+	string(ReqTagKeys):    &TagKeysEndpoint,
+	string(ReqTagValue):   &TagValuesEndpoint,
 }
 
 // GetConfig reads in the config file.
+// Invalid config values are logged as warnings and the Config is returned as read.
+//
+// Deprecated: GetConfig exits if the config file cannot be read and does not validate it, use LoadConfig instead.
 func GetConfig(filePath string) *Config {
-	viper.SetConfigFile(filePath)
-	err := viper.ReadInConfig()
-	if err != nil {
+	v := newViper(``)
+	v.SetConfigFile(filePath)
+	if err := v.ReadInConfig(); err != nil {
 		log.Fatalf("Unable to Read Config: %v\n", err)
 	}
-	viper.SetDefault(`http.address`, `:8080`)
-	viper.SetDefault(`loglevel`, `info`)
-	return &Config{
-		LogLevel:    viper.GetString(`loglevel`),
-		HTTPAddress: viper.GetString(`http.address`),
+	config, err := decodeConfig(v)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		log.Printf("Config Warning: %v\n", err)
+	}
+	return config
+}
+
+// LoadConfig reads and validates the config file.
+// When envPrefix is set, environment variables such as PREFIX_HTTP_ADDRESS override the config file values.
+func LoadConfig(filePath string, envPrefix string) (*Config, error) {
+	v := newViper(envPrefix)
+	v.SetConfigFile(filePath)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return configFromViper(v)
+}
+
+// LoadConfigReader reads and validates the config from the Reader, using the given config type (yaml, json, toml, ...).
+// When envPrefix is set, environment variables override the config values.
+func LoadConfigReader(r io.Reader, configType string, envPrefix string) (*Config, error) {
+	v := newViper(envPrefix)
+	v.SetConfigType(configType)
+	if err := v.ReadConfig(r); err != nil {
+		return nil, err
+	}
+	return configFromViper(v)
+}
+
+func newViper(envPrefix string) *viper.Viper {
+	v := viper.New()
+	v.SetDefault(`name`, applicationName)
+	v.SetDefault(`http.address`, `:8080`)
+	v.SetDefault(`loglevel`, `info`)
+	if envPrefix != `` {
+		v.SetEnvPrefix(envPrefix)
+		v.SetEnvKeyReplacer(strings.NewReplacer(`.`, `_`))
+		v.AutomaticEnv()
+	}
+	return v
+}
+
+func configFromViper(v *viper.Viper) (*Config, error) {
+	config, err := decodeConfig(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// decodeConfig decodes the viper values into a Config, returning the values decoded so far on failure.
+func decodeConfig(v *viper.Viper) (*Config, error) {
+	config := &Config{
+		Name:        v.GetString(`name`),
+		LogLevel:    v.GetString(`loglevel`),
+		HTTPAddress: v.GetString(`http.address`),
+	}
+	if err := v.UnmarshalKey(`endpoints`, &config.Endpoints); err != nil {
+		return config, fmt.Errorf("invalid config: endpoints: %v", err)
+	}
+	if err := v.UnmarshalKey(`cache`, &config.Cache); err != nil {
+		return config, fmt.Errorf("invalid config: cache: %v", err)
+	}
+	if err := v.UnmarshalKey(`auth`, &config.Auth); err != nil {
+		return config, fmt.Errorf("invalid config: auth: %v", err)
+	}
+	if err := v.UnmarshalKey(`limits`, &config.Limits); err != nil {
+		return config, fmt.Errorf("invalid config: limits: %v", err)
+	}
+	config.Data = v.GetStringMap(`data`)
+	return config, nil
+}
+
+// Validate checks the Config values.
+func (c *Config) Validate() error {
+	if c.Name == `` {
+		return fmt.Errorf("invalid config: name cannot be empty")
+	}
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return fmt.Errorf("invalid config: loglevel: %v", err)
+	}
+	_, port, err := net.SplitHostPort(c.HTTPAddress)
+	if err != nil {
+		return fmt.Errorf("invalid config: http.address: %v", err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("invalid config: http.address: invalid port %q", port)
+	}
+	for name, ec := range c.Endpoints {
+		if _, ok := endpointNames[name]; !ok {
+			return fmt.Errorf("invalid config: endpoints: unknown endpoint %q", name)
+		}
+		if ec.Path != `` && !strings.HasPrefix(ec.Path, `/`) {
+			return fmt.Errorf("invalid config: endpoints.%s.path: must begin with /", name)
+		}
+		if ec.Timeout < 0 {
+			return fmt.Errorf("invalid config: endpoints.%s.timeout: cannot be negative", name)
+		}
+		for i, route := range ec.Targets {
+			if _, err := regexp.Compile(route.Match); err != nil {
+				return fmt.Errorf("invalid config: endpoints.%s.targets[%d].match: %v", name, i, err)
+			}
+			if route.Handler == `` {
+				return fmt.Errorf("invalid config: endpoints.%s.targets[%d].handler: cannot be empty", name, i)
+			}
+		}
+	}
+	if c.Cache.Enabled && c.Cache.TTL <= 0 {
+		return fmt.Errorf("invalid config: cache.ttl: must be positive")
+	}
+	for name, limit := range map[string]RateLimit{`perclient`: c.Limits.PerClient, `peridentity`: c.Limits.PerIdentity, `perendpoint`: c.Limits.PerEndpoint} {
+		if limit.Rate < 0 || limit.Burst < 0 {
+			return fmt.Errorf("invalid config: limits.%s: cannot be negative", name)
+		}
+	}
+	if c.Limits.MaxConcurrent < 0 {
+		return fmt.Errorf("invalid config: limits.maxconcurrent: cannot be negative")
+	}
+	for i, u := range c.Auth.Users {
+		if u.Username == `` {
+			return fmt.Errorf("invalid config: auth.users[%d].username: cannot be empty", i)
+		}
+	}
+	return nil
+}
+
+// ApplyConfig configures the Endpoints, BEHandlers, cache, auth, limits and data declared in the Config.
+// BEHandlers are resolved by name from those added with RegisterHandler. Endpoints without a declared
+// Handler or Targets keep the BEHandler set with SetSearch, SetQuery, etc.
+// Must be called before Configure.
+func (g *GrafanaBackend) ApplyConfig(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	for name, ec := range config.Endpoints {
+		ep := endpointNames[name]
+		handler, err := ec.handler()
+		if err != nil {
+			return fmt.Errorf("endpoints.%s: %v", name, err)
+		}
+		if handler == nil {
+			handler = g.beHandlers[*ep]
+		}
+		if handler != nil && ec.Timeout > 0 {
+			handler = timeoutHandler(handler, ec.Timeout)
+		}
+		path := ec.Path
+		if path == `` {
+			path = string(*ep)
+		}
+		if ep == &RootEndpoint {
+			g.SetRoot(path)
+			continue
+		}
+		delete(g.beHandlers, *ep)
+		*ep = Endpoint(path)
+		if handler != nil {
+			g.beHandlers[*ep] = handler
+		}
+	}
+	if config.Cache.Enabled {
+		g.SetCache(NewResponseCache(config.Cache.TTL, config.Cache.MaxEntries, config.Cache.MaxBytes))
+	}
+	if len(config.Auth.Users) > 0 {
+		g.SetBasicAuth(NewBasicAuth(config.Auth.users()))
+	}
+	if config.Limits.enabled() {
+		l := NewLimiter(config.Limits.PerClient, config.Limits.PerIdentity, config.Limits.PerEndpoint, config.Limits.MaxConcurrent)
+		l.TrustForwardedFor = config.Limits.TrustForwardedFor
+		g.SetLimiter(l)
+	}
+	g.configLock.Lock()
+	g.config = config
+	g.data = config.Data
+	g.configLock.Unlock()
+	return nil
+}
+
+// handler builds the BEHandler declared by the EndpointConfig, or nil if none is declared.
+func (ec EndpointConfig) handler() (BEHandler, error) {
+	if ec.Handler == `` && len(ec.Targets) == 0 {
+		return nil, nil
+	}
+	def := BEHandler(defaultBEHandler)
+	if ec.Handler != `` {
+		h, ok := LookupHandler(ec.Handler)
+		if !ok {
+			return nil, fmt.Errorf("unknown handler %q", ec.Handler)
+		}
+		def = h
+	}
+	if len(ec.Targets) == 0 {
+		return def, nil
+	}
+	routes := make([]targetRoute, 0, len(ec.Targets))
+	for _, route := range ec.Targets {
+		h, ok := LookupHandler(route.Handler)
+		if !ok {
+			return nil, fmt.Errorf("unknown handler %q", route.Handler)
+		}
+		routes = append(routes, targetRoute{
+			match:   regexp.MustCompile(route.Match),
+			handler: h,
+		})
+	}
+	return routeHandler(def, routes), nil
+}
+
+// users returns the configured usernames and passwords.
+func (a AuthConfig) users() map[string]string {
+	users := make(map[string]string, len(a.Users))
+	for _, u := range a.Users {
+		users[u.Username] = u.Password
 	}
+	return users
 }
@@ -0,0 +1,40 @@
+package jsonds
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigReader(t *testing.T) {
+	tests := []struct {
+		name, yaml string
+		wantErr    bool
+	}{
+		{`defaults`, ``, false},
+		{`address`, "http:\n  address: \":9090\"\n", false},
+		{`loglevel`, "loglevel: bogus\n", true},
+		{`address`, "http:\n  address: \"nope\"\n", true},
+	}
+	for _, tt := range tests {
+		config, err := LoadConfigReader(strings.NewReader(tt.yaml), `yaml`, ``)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && config.Name != applicationName {
+			t.Errorf("%v: name = %q, want the default %q", tt.name, config.Name, applicationName)
+		}
+	}
+}
+
+func TestGetConfigInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), `config.yaml`)
+	if err := os.WriteFile(path, []byte("loglevel: bogus\nhttp:\n  address: \":9090\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := GetConfig(path)
+	if config == nil || config.HTTPAddress != `:9090` || config.LogLevel != `bogus` {
+		t.Errorf("GetConfig = %+v, want the config as read", config)
+	}
+}
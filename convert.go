@@ -0,0 +1,222 @@
+package jsonds
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// Target Types sent by Grafana:
+const (
+	TargetTimeSerie = `timeserie`
+	TargetTable     = `table`
+)
+
+// Column names used when converting TimeSeriesData into TableData:
+const (
+	ColumnTime   = `time`
+	ColumnMetric = `metric`
+	ColumnValue  = `value`
+)
+
+// SeriesToWideTable converts TimeSeriesData into a TableData with a time column
+// followed by one number column per series, with a row per distinct timestamp.
+// Missing values are left as nil.
+func SeriesToWideTable(data []TimeSeriesData) TableData {
+	td := NewTableData(len(data) + 1)
+	td.InsertColumn(ColumnTime, string(KeyTypeTime))
+	rows := make(map[int64][]interface{})
+	for i, d := range data {
+		td.InsertColumn(d.Target, string(KeyTypeNumber))
+		for _, dp := range d.Datapoints {
+			row, ok := rows[dp.UnixTimestampMS]
+			if !ok {
+				row = make([]interface{}, len(data)+1)
+				row[0] = dp.UnixTimestampMS
+				rows[dp.UnixTimestampMS] = row
+			}
+			row[i+1] = dp.MetricValue
+		}
+	}
+	for _, ts := range sortedTimestamps(rows) {
+		td.InsertRow(rows[ts]...)
+	}
+	return td
+}
+
+// SeriesToLongTable converts TimeSeriesData into a TableData with time, metric and value columns
+// plus a string column per label key, with a row per Datapoint.
+func SeriesToLongTable(data []TimeSeriesData) TableData {
+	labels := make(Labels)
+	for _, d := range data {
+		for k := range d.Labels {
+			labels[k] = ``
+		}
+	}
+	keys := labels.Keys()
+	td := NewTableData(len(keys) + 3)
+	td.InsertColumn(ColumnTime, string(KeyTypeTime))
+	td.InsertColumn(ColumnMetric, string(KeyTypeString))
+	for _, k := range keys {
+		td.InsertColumn(k, string(KeyTypeString))
+	}
+	td.InsertColumn(ColumnValue, string(KeyTypeNumber))
+	for _, d := range data {
+		for _, dp := range d.Datapoints {
+			row := make([]interface{}, 0, len(keys)+3)
+			row = append(row, dp.UnixTimestampMS, d.Target)
+			for _, k := range keys {
+				row = append(row, d.Labels[k])
+			}
+			row = append(row, dp.MetricValue)
+			td.InsertRow(row...)
+		}
+	}
+	sort.SliceStable(td.Rows, func(i, j int) bool {
+		return td.Rows[i][0].(int64) < td.Rows[j][0].(int64)
+	})
+	return td
+}
+
+// TableToSeries converts a TableData with a time column into TimeSeriesData.
+// Each number column becomes a series per distinct combination of the string column values,
+// which are carried as Labels. A metric column, if present, names the series instead of the column.
+func TableToSeries(td TableData) ([]TimeSeriesData, error) {
+	timeCol, metricCol := -1, -1
+	var numberCols, stringCols []int
+	for i, c := range td.Columns {
+		switch {
+		case KeyType(c.Type) == KeyTypeTime && timeCol < 0:
+			timeCol = i
+		case KeyType(c.Type) == KeyTypeNumber:
+			numberCols = append(numberCols, i)
+		case c.Text == ColumnMetric:
+			metricCol = i
+		default:
+			stringCols = append(stringCols, i)
+		}
+	}
+	if timeCol < 0 {
+		return nil, fmt.Errorf("table has no column of type %v", KeyTypeTime)
+	}
+	if len(numberCols) == 0 {
+		return nil, fmt.Errorf("table has no column of type %v", KeyTypeNumber)
+	}
+	var series []TimeSeriesData
+	index := make(map[string]int)
+	for r, row := range td.Rows {
+		if len(row) != len(td.Columns) {
+			return nil, fmt.Errorf("row %d: number of Row elements do not match the number of Table Columns", r)
+		}
+		ts, err := toUnixMS(row[timeCol])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid time value: %v", r, err)
+		}
+		labels := make(Labels, len(stringCols))
+		for _, c := range stringCols {
+			labels[td.Columns[c].Text] = cast.ToString(row[c])
+		}
+		for _, c := range numberCols {
+			if row[c] == nil {
+				continue
+			}
+			val, err := cast.ToFloat64E(row[c])
+			if err != nil {
+				return nil, fmt.Errorf("row %d: invalid value for column %v: %v", r, td.Columns[c].Text, err)
+			}
+			name := td.Columns[c].Text
+			if metricCol >= 0 {
+				name = cast.ToString(row[metricCol])
+			}
+			if len(labels) > 0 {
+				name += ` ` + labels.String()
+			}
+			i, ok := index[name]
+			if !ok {
+				i = len(series)
+				index[name] = i
+				series = append(series, TimeSeriesData{Target: name, Labels: labels})
+			}
+			series[i].AddDataPoint(val, ts)
+		}
+	}
+	for i := range series {
+		dps := series[i].Datapoints
+		sort.SliceStable(dps, func(a, b int) bool { return dps[a].UnixTimestampMS < dps[b].UnixTimestampMS })
+	}
+	return series, nil
+}
+
+// ConvertResponse converts TimeSeries and Table responses into the format requested by the given Target Type.
+// Responses already matching the Target Type, or of any other type, are returned unchanged.
+func ConvertResponse(targetType string, resp Response) (Response, error) {
+	switch r := resp.(type) {
+	case TimeSeriesResponse:
+		if targetType == TargetTable {
+			return TableResponse{Data: []TableData{SeriesToWideTable(r.Data)}}, nil
+		}
+	case TableResponse:
+		if targetType == TargetTimeSerie {
+			var ts TimeSeriesResponse
+			for _, td := range r.Data {
+				series, err := TableToSeries(td)
+				if err != nil {
+					return resp, err
+				}
+				ts.Data = append(ts.Data, series...)
+			}
+			return ts, nil
+		}
+	}
+	return resp, nil
+}
+
+// AutoFormat wraps a BEHandler, converting Query responses to the format requested by the Targets.
+// Conversion only happens when all Targets of the QueryRequest request the same Type.
+// Partial responses returned along with an error are converted too, and returned with the error.
+func AutoFormat(handler BEHandler) BEHandler {
+	return func(req Request) (Response, error) {
+		resp, err := handler(req)
+		if resp == nil || resp.RespType() == RespInvalid || req.ReqType() != ReqQuery {
+			return resp, err
+		}
+		targets := req.Query().Targets
+		if len(targets) == 0 {
+			return resp, err
+		}
+		for _, t := range targets[1:] {
+			if t.Type != targets[0].Type {
+				return resp, err
+			}
+		}
+		converted, convErr := ConvertResponse(targets[0].Type, resp)
+		if convErr != nil {
+			return converted, errors.Join(err, convErr)
+		}
+		return converted, err
+	}
+}
+
+func toUnixMS(v interface{}) (int64, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return toMS(t), nil
+	case string:
+		if tm, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return toMS(tm), nil
+		}
+	}
+	return cast.ToInt64E(v)
+}
+
+func sortedTimestamps(rows map[int64][]interface{}) []int64 {
+	timestamps := make([]int64, 0, len(rows))
+	for ts := range rows {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps
+}
@@ -0,0 +1,75 @@
+package jsonds
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTableToSeries(t *testing.T) {
+	td := NewTableData(3)
+	td.InsertColumn(`time`, string(KeyTypeTime))
+	td.InsertColumn(`host`, string(KeyTypeString))
+	td.InsertColumn(`cpu`, string(KeyTypeNumber))
+	td.InsertRow(int64(2000), `h1`, 2.0)
+	td.InsertRow(int64(1000), `h1`, 1.0)
+	td.InsertRow(int64(1000), `h2`, nil)
+	got, err := TableToSeries(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TimeSeriesData{
+		{Target: `cpu {host="h1"}`, Labels: Labels{`host`: `h1`}, Datapoints: []Datapoint{{1, 1000}, {2, 2000}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TableToSeries = %+v, want %+v", got, want)
+	}
+}
+
+func TestAutoFormat(t *testing.T) {
+	series := TimeSeriesResponse{Data: []TimeSeriesData{
+		{Target: `a`, Datapoints: []Datapoint{{1, 1000}}},
+	}}
+	handler := AutoFormat(func(Request) (Response, error) { return series, nil })
+	tests := []struct {
+		name  string
+		types []string
+		want  ResponseType
+	}{
+		{`table`, []string{TargetTable}, RespTable},
+		{`timeserie`, []string{TargetTimeSerie}, RespTimeSeries},
+		{`mixed`, []string{TargetTable, TargetTimeSerie}, RespTimeSeries},
+		{`no targets`, nil, RespTimeSeries},
+	}
+	for _, tt := range tests {
+		q := &QueryRequest{}
+		for _, typ := range tt.types {
+			q.Targets = append(q.Targets, Target{Target: `a`, Type: typ})
+		}
+		resp, err := handler(q)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if resp.RespType() != tt.want {
+			t.Errorf("%v: response type = %v, want %v", tt.name, resp.RespType(), tt.want)
+		}
+	}
+}
+
+func TestAutoFormatPartial(t *testing.T) {
+	partial := errors.New("upstream b failed")
+	handler := AutoFormat(func(Request) (Response, error) {
+		return TimeSeriesResponse{Data: []TimeSeriesData{{Target: `a`, Datapoints: []Datapoint{{1, 1000}}}}}, partial
+	})
+	resp, err := handler(&QueryRequest{Targets: []Target{{Target: `a`, Type: TargetTable}, {Target: `b`, Type: TargetTable}}})
+	if !errors.Is(err, partial) {
+		t.Errorf("error = %v, want the partial failure", err)
+	}
+	if resp.RespType() != RespTable {
+		t.Errorf("response type = %v, want %v", resp.RespType(), RespTable)
+	}
+	failed := AutoFormat(func(Request) (Response, error) { return InvalidData{}, partial })
+	if resp, err := failed(&QueryRequest{Targets: []Target{{Target: `a`, Type: TargetTable}}}); resp.RespType() != RespInvalid || err != partial {
+		t.Errorf("failed query = %v, %v, want an invalid response and the error", resp, err)
+	}
+}
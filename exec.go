@@ -0,0 +1,247 @@
+package jsonds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// ExecEnvPrefix prefixes the environment variables passed to commands run by the ExecBackend.
+const ExecEnvPrefix = `JSONDS_`
+
+// execWaitDelay is the time waited for the output of a cancelled command to be closed,
+// in case processes it started still hold it open.
+const execWaitDelay = time.Second
+
+var envName = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ExecBackend runs a configured command per target and parses its stdout as the Response.
+//
+// The decoded Request, limited to the target, is written to the command stdin as JSON.
+// The following environment variables are set in addition to the environment of the process:
+//
+//	JSONDS_TARGET, JSONDS_TYPE              target name and type
+//	JSONDS_FROM, JSONDS_TO                  Range in RFC3339
+//	JSONDS_FROM_MS, JSONDS_TO_MS            Range in unix milliseconds
+//	JSONDS_INTERVAL_MS, JSONDS_MAX_POINTS   interval and maxDataPoints
+//	JSONDS_VAR_<NAME>                       scoped variable values, comma separated
+//	JSONDS_DATA_<NAME>                      target data values, comma separated
+//
+// Stdout must hold a JSON array of TimeSeriesData or TableData for queries, and of AnnotationResponses
+// for annotations. Commands are killed after Timeout or once stdout exceeds MaxOutput bytes,
+// along with the processes they started on Unix systems.
+type ExecBackend struct {
+	Timeout   time.Duration
+	MaxOutput int
+
+	commands map[string][]string
+	lock     sync.RWMutex
+}
+
+// NewExecBackend returns a new ExecBackend.
+func NewExecBackend(timeout time.Duration, maxOutput int) *ExecBackend {
+	return &ExecBackend{
+		Timeout:   timeout,
+		MaxOutput: maxOutput,
+		commands:  make(map[string][]string),
+	}
+}
+
+// AddCommand sets the command run for the given target. For annotations, the target is the annotation name.
+func (b *ExecBackend) AddCommand(target string, name string, args ...string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.commands[target] = append([]string{name}, args...)
+}
+
+// Targets returns the sorted target names with a command.
+func (b *ExecBackend) Targets() []string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	targets := make([]string, 0, len(b.commands))
+	for t := range b.commands {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// BEHandler returns a BEHandler answering Search, Query and Annotation Requests.
+func (b *ExecBackend) BEHandler() BEHandler {
+	return func(req Request) (Response, error) {
+		switch req.ReqType() {
+		case ReqSearch:
+			var resp SearchResponse
+			for _, t := range b.Targets() {
+				if strings.Contains(t, req.Search().Target) {
+					resp.Data = append(resp.Data, t)
+				}
+			}
+			return resp, nil
+		case ReqQuery:
+			return b.query(req)
+		case ReqAnnotation:
+			a := req.Anno()
+			env := append(rangeEnv(a.Range), ExecEnvPrefix+`TARGET=`+a.Annotation.Name)
+			out, err := b.run(RequestContext(req), a.Annotation.Name, a, env)
+			if err != nil {
+				return InvalidData{}, err
+			}
+			var resp AnnotationsResponse
+			if err := json.Unmarshal(out, &resp.Data); err != nil {
+				return InvalidData{}, fmt.Errorf("exec backend: annotation %q: invalid output: %v", a.Annotation.Name, err)
+			}
+			for i := range resp.Data {
+				resp.Data[i].Annotation = a.Annotation
+			}
+			return resp, nil
+		}
+		return defaultBEHandler(req)
+	}
+}
+
+func (b *ExecBackend) query(req Request) (Response, error) {
+	q := req.Query()
+	var series TimeSeriesResponse
+	var tables TableResponse
+	for _, t := range q.Targets {
+		sub := *q
+		sub.Targets = []Target{t}
+		env := append(rangeEnv(q.Range),
+			ExecEnvPrefix+`TARGET=`+t.Target,
+			ExecEnvPrefix+`TYPE=`+t.Type,
+			ExecEnvPrefix+`INTERVAL_MS=`+strconv.FormatInt(q.InvervalMS, 10),
+			ExecEnvPrefix+`MAX_POINTS=`+strconv.Itoa(q.MaxDataPoints),
+		)
+		for name, pair := range q.ScopedVars {
+			env = append(env, ExecEnvPrefix+`VAR_`+envKey(name)+`=`+strings.Join(pair.ValueStrings(), `,`))
+		}
+		for name := range t.Data {
+			env = append(env, ExecEnvPrefix+`DATA_`+envKey(name)+`=`+strings.Join(t.GetVarStrings(name), `,`))
+		}
+		out, err := b.run(RequestContext(req), t.Target, &sub, env)
+		if err != nil {
+			return InvalidData{}, err
+		}
+		var objs []map[string]json.RawMessage
+		if err := json.Unmarshal(out, &objs); err != nil {
+			return InvalidData{}, fmt.Errorf("exec backend: target %q: invalid output: %v", t.Target, err)
+		}
+		if len(objs) > 0 && objs[0][`columns`] != nil {
+			var td []TableData
+			if err := json.Unmarshal(out, &td); err != nil {
+				return InvalidData{}, fmt.Errorf("exec backend: target %q: invalid table output: %v", t.Target, err)
+			}
+			tables.Data = append(tables.Data, td...)
+			continue
+		}
+		var data []TimeSeriesData
+		if err := json.Unmarshal(out, &data); err != nil {
+			return InvalidData{}, fmt.Errorf("exec backend: target %q: invalid time series output: %v", t.Target, err)
+		}
+		series.Data = append(series.Data, q.Process(&t, data)...)
+	}
+	if len(tables.Data) > 0 {
+		if len(series.Data) > 0 {
+			tables.Data = append(tables.Data, SeriesToWideTable(series.Data))
+		}
+		return tables, nil
+	}
+	return series, nil
+}
+
+// run executes the command of the target with the JSON encoded input on stdin and returns its stdout.
+func (b *ExecBackend) run(ctx context.Context, target string, input interface{}, env []string) ([]byte, error) {
+	b.lock.RLock()
+	argv, ok := b.commands[target]
+	b.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("exec backend: no command for target %q", target)
+	}
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+	ctx, kill := context.WithCancel(ctx)
+	defer kill()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.WaitDelay = execWaitDelay
+	setProcessGroup(cmd)
+	stdout := &limitedBuffer{max: b.MaxOutput, exceeded: kill}
+	stderr := &limitedBuffer{max: 4096}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	switch {
+	case stdout.truncated:
+		return nil, fmt.Errorf("exec backend: target %q: output exceeds %d bytes", target, b.MaxOutput)
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, fmt.Errorf("exec backend: target %q: timeout after %v", target, b.Timeout)
+	case err != nil:
+		return nil, fmt.Errorf("exec backend: target %q: %v: %s", target, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// rangeEnv returns the environment variables describing the Range.
+func rangeEnv(r Range) []string {
+	return []string{
+		ExecEnvPrefix + `FROM=` + r.From.Format(time.RFC3339),
+		ExecEnvPrefix + `TO=` + r.To.Format(time.RFC3339),
+		ExecEnvPrefix + `FROM_MS=` + cast.ToString(toMS(r.From)),
+		ExecEnvPrefix + `TO_MS=` + cast.ToString(toMS(r.To)),
+	}
+}
+
+func envKey(name string) string {
+	return strings.ToUpper(envName.ReplaceAllString(name, `_`))
+}
+
+// limitedBuffer buffers up to max bytes, calling exceeded once more is written. A zero max is unlimited.
+// The buffer is not embedded so that io.Copy cannot bypass Write through ReadFrom.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+	exceeded  func()
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && b.buf.Len()+len(p) > b.max {
+		b.buf.Write(p[:b.max-b.buf.Len()])
+		if !b.truncated {
+			b.truncated = true
+			if b.exceeded != nil {
+				b.exceeded()
+			}
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
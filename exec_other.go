@@ -0,0 +1,9 @@
+//go:build !unix
+
+package jsonds
+
+import "os/exec"
+
+// setProcessGroup is a no-op where process groups are not supported.
+// Processes started by the command are left running when it is cancelled.
+func setProcessGroup(cmd *exec.Cmd) {}
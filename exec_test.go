@@ -0,0 +1,59 @@
+package jsonds
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecBackend(t *testing.T) {
+	if _, err := exec.LookPath(`sh`); err != nil {
+		t.Skip(`sh not available`)
+	}
+	b := NewExecBackend(5*time.Second, 1024)
+	b.AddCommand(`series`, `sh`, `-c`, `echo "[{\"target\":\"$JSONDS_TARGET\",\"datapoints\":[[1,$JSONDS_FROM_MS]]}]"`)
+	b.AddCommand(`fails`, `sh`, `-c`, `echo oops >&2; exit 3`)
+	b.AddCommand(`large`, `sh`, `-c`, `yes`)
+	handler := b.BEHandler()
+	rng := Range{From: time.Unix(1, 0), To: time.Unix(2, 0)}
+
+	resp, err := handler(&QueryRequest{Range: rng, Targets: []Target{{Target: `series`}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TimeSeriesData{{Target: `series`, Datapoints: []Datapoint{{1, 1000}}}}
+	if got := resp.(TimeSeriesResponse).Data; !reflect.DeepEqual(got, want) {
+		t.Errorf("series = %+v, want %+v", got, want)
+	}
+	tests := []struct {
+		target, err string
+	}{
+		{`fails`, `oops`},
+		{`large`, `output exceeds`},
+		{`unknown`, `no command`},
+	}
+	for _, tt := range tests {
+		_, err := handler(&QueryRequest{Range: rng, Targets: []Target{{Target: tt.target}}})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v: err = %v, want %q", tt.target, err, tt.err)
+		}
+	}
+}
+
+func TestExecBackendTimeoutKillsChildren(t *testing.T) {
+	if _, err := exec.LookPath(`sh`); err != nil {
+		t.Skip(`sh not available`)
+	}
+	b := NewExecBackend(50*time.Millisecond, 0)
+	b.AddCommand(`slow`, `sh`, `-c`, `sleep 30 & sleep 30`)
+	start := time.Now()
+	_, err := b.BEHandler()(&QueryRequest{Targets: []Target{{Target: `slow`}}})
+	if err == nil || !strings.Contains(err.Error(), `timeout`) {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > execWaitDelay+time.Second {
+		t.Errorf("timed out command returned after %v", elapsed)
+	}
+}
@@ -0,0 +1,460 @@
+package jsonds
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cast"
+)
+
+// ExpressionVar is the Target Data variable marking the Target as an expression over the other Targets of
+// the QueryRequest, e.g. A / B * 100 or rate(requests) - rate(errors). Expressions are evaluated by ResolveTargets.
+//
+// Targets are referenced by refId or target name, optionally prefixed with $. Names which are not plain
+// identifiers, such as kafka-lag, can be quoted. Expressions support:
+//
+//	arithmetic      + - * / %
+//	comparison      == != < <= > >=, resulting in 1 or 0
+//	pointwise       abs, ceil, floor, round, sqrt, log and exp
+//	rate, delta     per second rate and difference between consecutive points
+//	aggregation     sum, avg, min and max of all series into one
+//
+// Series are aligned by timestamp, keeping only timestamps present on both sides. A single series is combined
+// with each series of the other side, otherwise series are paired by labels. Points resulting in NaN or
+// infinity, e.g. divisions by zero, are dropped.
+const ExpressionVar = `expression`
+
+// IsExpression returns true if the Target is an expression.
+func (t *Target) IsExpression() bool {
+	return cast.ToBool(t.Data[ExpressionVar])
+}
+
+// eval evaluates the ExprNode over the Targets of the QueryRequest.
+func (r *targetResolver) eval(n *ExprNode) (exprValue, error) {
+	switch n.Kind {
+	case ExprNumber:
+		return exprValue{scalar: true, num: n.Num}, nil
+	case ExprRef:
+		i, ok := r.resolve(n.Name)
+		if !ok {
+			return exprValue{}, fmt.Errorf("unknown target %q", n.Name)
+		}
+		data, err := r.target(i)
+		if err != nil {
+			return exprValue{}, err
+		}
+		copied := make([]TimeSeriesData, len(data))
+		for j, d := range data {
+			copied[j] = d
+			copied[j].Datapoints = append([]Datapoint(nil), d.Datapoints...)
+		}
+		return exprValue{series: copied}, nil
+	case ExprUnary:
+		v, err := r.eval(n.Args[0])
+		if err != nil {
+			return exprValue{}, err
+		}
+		return v.apply(func(x float64) float64 { return -x }), nil
+	case ExprBinary:
+		a, err := r.eval(n.Args[0])
+		if err != nil {
+			return exprValue{}, err
+		}
+		b, err := r.eval(n.Args[1])
+		if err != nil {
+			return exprValue{}, err
+		}
+		return binaryOp(n.Op, a, b)
+	case ExprCall:
+		args := make([]exprValue, len(n.Args))
+		for i, a := range n.Args {
+			v, err := r.eval(a)
+			if err != nil {
+				return exprValue{}, err
+			}
+			args[i] = v
+		}
+		return callFunc(n, args)
+	}
+	return exprValue{}, fmt.Errorf("invalid expression %v", n)
+}
+
+// exprValue is either a scalar number or a set of series.
+type exprValue struct {
+	scalar bool
+	num    float64
+	series []TimeSeriesData
+}
+
+// apply applies fn to the number or every point of the series, dropping NaN and infinite results.
+func (v exprValue) apply(fn func(float64) float64) exprValue {
+	if v.scalar {
+		return exprValue{scalar: true, num: fn(v.num)}
+	}
+	for i := range v.series {
+		points := v.series[i].Datapoints[:0]
+		for _, dp := range v.series[i].Datapoints {
+			dp.MetricValue = fn(dp.MetricValue)
+			if validNumber(dp.MetricValue) {
+				points = append(points, dp)
+			}
+		}
+		v.series[i].Datapoints = points
+	}
+	return v
+}
+
+func validNumber(x float64) bool {
+	return !math.IsNaN(x) && !math.IsInf(x, 0)
+}
+
+// binaryOps are the functions of the binary operators.
+var binaryOps = map[string]func(a, b float64) float64{
+	`+`:  func(a, b float64) float64 { return a + b },
+	`-`:  func(a, b float64) float64 { return a - b },
+	`*`:  func(a, b float64) float64 { return a * b },
+	`/`:  func(a, b float64) float64 { return a / b },
+	`%`:  math.Mod,
+	`==`: func(a, b float64) float64 { return boolValue(a == b) },
+	`!=`: func(a, b float64) float64 { return boolValue(a != b) },
+	`<`:  func(a, b float64) float64 { return boolValue(a < b) },
+	`<=`: func(a, b float64) float64 { return boolValue(a <= b) },
+	`>`:  func(a, b float64) float64 { return boolValue(a > b) },
+	`>=`: func(a, b float64) float64 { return boolValue(a >= b) },
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func binaryOp(op string, a, b exprValue) (exprValue, error) {
+	fn := binaryOps[op]
+	switch {
+	case a.scalar && b.scalar:
+		return exprValue{scalar: true, num: fn(a.num, b.num)}, nil
+	case b.scalar:
+		return a.apply(func(x float64) float64 { return fn(x, b.num) }), nil
+	case a.scalar:
+		return b.apply(func(x float64) float64 { return fn(a.num, x) }), nil
+	}
+	var out []TimeSeriesData
+	switch {
+	case len(b.series) == 1:
+		for _, s := range a.series {
+			out = append(out, alignSeries(s, s, b.series[0], fn))
+		}
+	case len(a.series) == 1:
+		for _, s := range b.series {
+			out = append(out, alignSeries(s, a.series[0], s, fn))
+		}
+	default:
+		index := make(map[string]TimeSeriesData, len(b.series))
+		for _, s := range b.series {
+			index[s.Labels.String()] = s
+		}
+		for _, s := range a.series {
+			if other, ok := index[s.Labels.String()]; ok {
+				out = append(out, alignSeries(s, s, other, fn))
+			}
+		}
+		if len(out) == 0 && len(a.series) > 0 {
+			return exprValue{}, fmt.Errorf("no series with matching labels for %v", op)
+		}
+	}
+	return exprValue{series: out}, nil
+}
+
+// alignSeries combines the points of a and b sharing a timestamp into a series named after named.
+func alignSeries(named, a, b TimeSeriesData, fn func(a, b float64) float64) TimeSeriesData {
+	values := make(map[int64]float64, len(b.Datapoints))
+	for _, dp := range b.Datapoints {
+		values[dp.UnixTimestampMS] = dp.MetricValue
+	}
+	out := TimeSeriesData{Target: named.Target, Labels: named.Labels}
+	for _, dp := range a.Datapoints {
+		if y, ok := values[dp.UnixTimestampMS]; ok {
+			if v := fn(dp.MetricValue, y); validNumber(v) {
+				out.AddDataPoint(v, dp.UnixTimestampMS)
+			}
+		}
+	}
+	return out
+}
+
+// pointFuncs are the functions applied to each value.
+var pointFuncs = map[string]func(float64) float64{
+	`abs`:   math.Abs,
+	`ceil`:  math.Ceil,
+	`floor`: math.Floor,
+	`round`: math.Round,
+	`sqrt`:  math.Sqrt,
+	`log`:   math.Log,
+	`exp`:   math.Exp,
+}
+
+// aggregateFuncs map the aggregation functions to the seriesReducers.
+var aggregateFuncs = map[string]string{
+	`sum`: `sumSeries`,
+	`avg`: `averageSeries`,
+	`min`: `minSeries`,
+	`max`: `maxSeries`,
+}
+
+func callFunc(n *ExprNode, args []exprValue) (exprValue, error) {
+	if fn, ok := pointFuncs[n.Name]; ok {
+		if len(args) != 1 {
+			return exprValue{}, fmt.Errorf("%v: expected 1 argument, got %d", n.Name, len(args))
+		}
+		return args[0].apply(fn), nil
+	}
+	if reducer, ok := aggregateFuncs[n.Name]; ok {
+		var data []TimeSeriesData
+		for _, a := range args {
+			if a.scalar {
+				return exprValue{}, fmt.Errorf("%v: expected series arguments", n.Name)
+			}
+			data = append(data, a.series...)
+		}
+		return exprValue{series: []TimeSeriesData{reduceSeries(n.String(), data, seriesReducers[reducer])}}, nil
+	}
+	switch n.Name {
+	case `rate`, `delta`:
+		if len(args) != 1 || args[0].scalar {
+			return exprValue{}, fmt.Errorf("%v: expected 1 series argument", n.Name)
+		}
+		for i, s := range args[0].series {
+			out := TimeSeriesData{Target: s.Target, Labels: s.Labels}
+			for j := 1; j < len(s.Datapoints); j++ {
+				prev, cur := s.Datapoints[j-1], s.Datapoints[j]
+				v := cur.MetricValue - prev.MetricValue
+				if n.Name == `rate` {
+					if v < 0 {
+						// counter reset
+						v = cur.MetricValue
+					}
+					v /= float64(cur.UnixTimestampMS-prev.UnixTimestampMS) / 1000
+				}
+				if validNumber(v) {
+					out.AddDataPoint(v, cur.UnixTimestampMS)
+				}
+			}
+			args[0].series[i] = out
+		}
+		return args[0], nil
+	}
+	return exprValue{}, fmt.Errorf("unknown function %q", n.Name)
+}
+
+// ExprKind is the kind of an ExprNode.
+type ExprKind int
+
+// ExprNode kinds:
+const (
+	ExprNumber ExprKind = iota
+	ExprRef
+	ExprUnary
+	ExprBinary
+	ExprCall
+)
+
+// ExprNode is a node of a parsed expression.
+type ExprNode struct {
+	Kind ExprKind
+	Num  float64
+	Name string
+	Op   string
+	Args []*ExprNode
+}
+
+// String returns the expression of the ExprNode.
+func (n *ExprNode) String() string {
+	switch n.Kind {
+	case ExprNumber:
+		return strconv.FormatFloat(n.Num, 'g', -1, 64)
+	case ExprRef:
+		if isExprIdent(n.Name) {
+			return n.Name
+		}
+		return strconv.Quote(n.Name)
+	case ExprUnary:
+		return `-` + n.Args[0].String()
+	case ExprBinary:
+		return `(` + n.Args[0].String() + ` ` + n.Op + ` ` + n.Args[1].String() + `)`
+	case ExprCall:
+		args := make([]string, len(n.Args))
+		for i, a := range n.Args {
+			args[i] = a.String()
+		}
+		return n.Name + `(` + strings.Join(args, `, `) + `)`
+	}
+	return ``
+}
+
+// exprPrecedence are the binary operators by increasing precedence.
+var exprPrecedence = [][]string{
+	{`==`, `!=`, `<=`, `>=`, `<`, `>`},
+	{`+`, `-`},
+	{`*`, `/`, `%`},
+}
+
+// ParseExpression parses an expression such as A / B * 100.
+func ParseExpression(expr string) (*ExprNode, error) {
+	p := &exprLexer{s: expr}
+	n, err := p.parseBinary(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", expr, err)
+	}
+	if p.skipSpace(); p.i < len(p.s) {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q at %d", expr, p.s[p.i:], p.i)
+	}
+	return n, nil
+}
+
+type exprLexer struct {
+	s string
+	i int
+}
+
+func (p *exprLexer) skipSpace() {
+	for p.i < len(p.s) && unicode.IsSpace(rune(p.s[p.i])) {
+		p.i++
+	}
+}
+
+// accept consumes the given token if next.
+func (p *exprLexer) accept(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.i:], tok) {
+		p.i += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *exprLexer) parseBinary(level int) (*ExprNode, error) {
+	if level == len(exprPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		for _, o := range exprPrecedence[level] {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == `` {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &ExprNode{Kind: ExprBinary, Op: op, Args: []*ExprNode{left, right}}
+	}
+}
+
+func (p *exprLexer) parseUnary() (*ExprNode, error) {
+	if p.accept(`-`) {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ExprNode{Kind: ExprUnary, Op: `-`, Args: []*ExprNode{n}}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprLexer) parsePrimary() (*ExprNode, error) {
+	p.skipSpace()
+	if p.i >= len(p.s) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	start := p.i
+	switch c := p.s[p.i]; {
+	case c == '(':
+		p.i++
+		n, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(`)`) {
+			return nil, fmt.Errorf("missing ) for ( at %d", start)
+		}
+		return n, nil
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(p.s[p.i+1:], c)
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed quote at %d", start)
+		}
+		p.i += end + 2
+		return &ExprNode{Kind: ExprRef, Name: p.s[start+1 : p.i-1]}, nil
+	case c >= '0' && c <= '9' || c == '.':
+		for p.i < len(p.s) && (strings.IndexByte(`0123456789.eE`, p.s[p.i]) >= 0 ||
+			(p.s[p.i] == '-' || p.s[p.i] == '+') && (p.s[p.i-1] == 'e' || p.s[p.i-1] == 'E')) {
+			p.i++
+		}
+		num, err := strconv.ParseFloat(p.s[start:p.i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", p.s[start:p.i], start)
+		}
+		return &ExprNode{Kind: ExprNumber, Num: num}, nil
+	}
+	if p.s[p.i] == '$' {
+		p.i++
+	}
+	nameStart := p.i
+	for p.i < len(p.s) && isExprIdentChar(rune(p.s[p.i])) {
+		p.i++
+	}
+	name := p.s[nameStart:p.i]
+	if name == `` {
+		return nil, fmt.Errorf("unexpected %q at %d", p.s[start:], start)
+	}
+	if !p.accept(`(`) {
+		return &ExprNode{Kind: ExprRef, Name: name}, nil
+	}
+	n := &ExprNode{Kind: ExprCall, Name: name}
+	if p.accept(`)`) {
+		return n, nil
+	}
+	for {
+		arg, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		n.Args = append(n.Args, arg)
+		if p.accept(`)`) {
+			return n, nil
+		}
+		if !p.accept(`,`) {
+			return nil, fmt.Errorf("missing ) for %v( at %d", name, start)
+		}
+	}
+}
+
+func isExprIdentChar(c rune) bool {
+	return c == '_' || c == '.' || c == ':' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+func isExprIdent(s string) bool {
+	if s == `` || unicode.IsDigit(rune(s[0])) || s[0] == '.' {
+		return false
+	}
+	for _, c := range s {
+		if !isExprIdentChar(c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package jsonds
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`A`, `A`},
+		{`$A`, `A`},
+		{`A + B * 2`, `(A + (B * 2))`},
+		{`(A + B) * 2`, `((A + B) * 2)`},
+		{`A - B - C`, `((A - B) - C)`},
+		{`A / B * 100 >= 50`, `(((A / B) * 100) >= 50)`},
+		{`-A % 3`, `(-A % 3)`},
+		{`1.5e3 + .5`, `(1500 + 0.5)`},
+		{`"kafka-lag" / 'total'`, `("kafka-lag" / total)`},
+		{`rate(requests) - rate(errors)`, `(rate(requests) - rate(errors))`},
+		{`sum(A, B * 2)`, `sum(A, (B * 2))`},
+		{`node.cpu:load`, `node.cpu:load`},
+	}
+	for _, tt := range tests {
+		n, err := ParseExpression(tt.expr)
+		if err != nil {
+			t.Errorf("ParseExpression(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := n.String(); got != tt.want {
+			t.Errorf("ParseExpression(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	for _, expr := range []string{``, `A +`, `(A + B`, `rate(A`, `"A`, `A B`, `1e`, `A + )`} {
+		if n, err := ParseExpression(expr); err == nil {
+			t.Errorf("ParseExpression(%q) = %v, want an error", expr, n)
+		}
+	}
+}
+
+func TestEvalExpression(t *testing.T) {
+	stored := map[string][]Datapoint{
+		`requests`: {{10, 1000}, {20, 2000}},
+		`errors`:   {{5, 1000}, {5, 2000}},
+	}
+	handler := ResolveTargets(func(req Request) (Response, error) {
+		var resp TimeSeriesResponse
+		for _, t := range req.Query().Targets {
+			dps, ok := stored[t.Target]
+			if !ok {
+				return InvalidData{}, errors.New("not found")
+			}
+			resp.Data = append(resp.Data, TimeSeriesData{Target: t.Target, Datapoints: dps})
+		}
+		return resp, nil
+	})
+	tests := []struct {
+		expr    string
+		want    []Datapoint
+		wantErr string
+	}{
+		{expr: `B / A * 100`, want: []Datapoint{{50, 1000}, {25, 2000}}},
+		{expr: `errors / requests * 100`, want: []Datapoint{{50, 1000}, {25, 2000}}},
+		{expr: `A > 15`, want: []Datapoint{{0, 1000}, {1, 2000}}},
+		{expr: `-A + abs(-2)`, want: []Datapoint{{-8, 1000}, {-18, 2000}}},
+		{expr: `rate(A)`, want: []Datapoint{{10, 2000}}},
+		{expr: `delta(B)`, want: []Datapoint{{0, 2000}}},
+		{expr: `sum(A, B)`, want: []Datapoint{{15, 1000}, {25, 2000}}},
+		{expr: `A / (B - 5)`},
+		{expr: `A + D`, wantErr: `unknown target "D"`},
+		{expr: `C + 1`, wantErr: `expression cycle`},
+		{expr: `1 + 2`, wantErr: `result is a number`},
+		{expr: `median(A)`, wantErr: `unknown function "median"`},
+	}
+	for _, tt := range tests {
+		q := &QueryRequest{Targets: []Target{
+			{RefID: `A`, Target: `requests`, Hide: true},
+			{RefID: `B`, Target: `errors`, Hide: true},
+			{RefID: `C`, Target: tt.expr, Data: map[string]interface{}{ExpressionVar: true}},
+		}}
+		resp, err := handler(q)
+		if tt.wantErr != `` {
+			var errs QueryErrors
+			if !errors.As(err, &errs) || len(errs) != 1 || errs[0].RefID != `C` || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%v: error = %v, want %q for refId C", tt.expr, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.expr, err)
+			continue
+		}
+		want := TimeSeriesResponse{Data: []TimeSeriesData{{Target: `C`, RefID: `C`, Datapoints: tt.want}}}
+		if !reflect.DeepEqual(resp, want) {
+			t.Errorf("%v: response = %+v, want %+v", tt.expr, resp, want)
+		}
+	}
+}
+
+func TestEvalExpressionLabels(t *testing.T) {
+	handler := ResolveTargets(func(req Request) (Response, error) {
+		host := func(h string, v float64) TimeSeriesData {
+			return TimeSeriesData{Target: req.Query().Targets[0].Target + `{` + h + `}`, Labels: Labels{`host`: h}, Datapoints: []Datapoint{{v, 1000}}}
+		}
+		if req.Query().Targets[0].Target == `used` {
+			return TimeSeriesResponse{Data: []TimeSeriesData{host(`h1`, 1), host(`h2`, 3)}}, nil
+		}
+		return TimeSeriesResponse{Data: []TimeSeriesData{host(`h2`, 4), host(`h1`, 4)}}, nil
+	})
+	resp, err := handler(&QueryRequest{Targets: []Target{
+		{RefID: `A`, Target: `used`, Hide: true},
+		{RefID: `B`, Target: `total`, Hide: true},
+		{RefID: `C`, Target: `A / B`, Data: map[string]interface{}{ExpressionVar: true}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TimeSeriesResponse{Data: []TimeSeriesData{
+		{Target: `used{h1}`, Labels: Labels{`host`: `h1`}, RefID: `C`, Datapoints: []Datapoint{{0.25, 1000}}},
+		{Target: `used{h2}`, Labels: Labels{`host`: `h2`}, RefID: `C`, Datapoints: []Datapoint{{0.75, 1000}}},
+	}}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
@@ -0,0 +1,414 @@
+package jsonds
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cast"
+)
+
+// File extensions served by the FileBackend:
+const (
+	FileCSV   = `.csv`
+	FileJSON  = `.json`
+	FileJSONL = `.jsonl`
+)
+
+// FileBackend serves CSV, JSON and JSONL files from a directory as targets named after the file without extension,
+// or with extension when several files share the same name.
+// CSV files require a header row, JSON files an array of objects and JSONL files an object per line.
+// Rows are filtered by the QueryRequest Range using the configured time column, holding RFC3339 strings
+// or unix timestamps in seconds or milliseconds.
+type FileBackend struct {
+	Dir        string
+	TimeColumn string
+
+	tables   map[string]TableData
+	modTimes map[string]time.Time
+	lock     sync.RWMutex
+}
+
+// NewFileBackend returns a new FileBackend with the files of the directory loaded.
+func NewFileBackend(dir, timeColumn string) (*FileBackend, error) {
+	b := &FileBackend{
+		Dir:        dir,
+		TimeColumn: timeColumn,
+		tables:     make(map[string]TableData),
+		modTimes:   make(map[string]time.Time),
+	}
+	return b, b.Load()
+}
+
+// Load (re)loads the files of the directory which changed since the last Load, dropping removed files.
+func (b *FileBackend) Load() error {
+	entries, err := os.ReadDir(b.Dir)
+	if err != nil {
+		return err
+	}
+	var files []os.DirEntry
+	names := make(map[string]int)
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != FileCSV && ext != FileJSON && ext != FileJSONL) {
+			continue
+		}
+		files = append(files, e)
+		names[strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))]++
+	}
+	seen := make(map[string]bool)
+	var errs []string
+	for _, e := range files {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		info, err := e.Info()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		target := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if names[target] > 1 {
+			target = e.Name()
+		}
+		seen[target] = true
+		b.lock.RLock()
+		unchanged := b.modTimes[target].Equal(info.ModTime())
+		b.lock.RUnlock()
+		if unchanged {
+			continue
+		}
+		td, err := b.loadFile(filepath.Join(b.Dir, e.Name()), ext)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", e.Name(), err))
+			continue
+		}
+		b.lock.Lock()
+		b.tables[target] = td
+		b.modTimes[target] = info.ModTime()
+		b.lock.Unlock()
+	}
+	b.lock.Lock()
+	for target := range b.tables {
+		if !seen[target] {
+			delete(b.tables, target)
+			delete(b.modTimes, target)
+		}
+	}
+	b.lock.Unlock()
+	if len(errs) > 0 {
+		return fmt.Errorf("file backend: %s", strings.Join(errs, `; `))
+	}
+	return nil
+}
+
+// Watch reloads the files whenever the directory changes. Load errors are passed to onError, if set.
+// Call the returned function to stop watching.
+func (b *FileBackend) Watch(onError func(error)) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(b.Dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go func() {
+		var debounce <-chan time.Time
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				debounce = time.After(reloadDebounce)
+			case <-debounce:
+				debounce = nil
+				if err := b.Load(); err != nil && onError != nil {
+					onError(err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				if onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+	return func() {
+		watcher.Close()
+	}, nil
+}
+
+// Targets returns the sorted target names.
+func (b *FileBackend) Targets() []string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	targets := make([]string, 0, len(b.tables))
+	for t := range b.tables {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// BEHandler returns a BEHandler answering Search, Query, TagKeys and TagValues Requests.
+// TagKeys lists the columns of all files and TagValues the distinct values of a column.
+func (b *FileBackend) BEHandler() BEHandler {
+	return func(req Request) (Response, error) {
+		switch req.ReqType() {
+		case ReqSearch:
+			var resp SearchResponse
+			for _, t := range b.Targets() {
+				if strings.Contains(t, req.Search().Target) {
+					resp.Data = append(resp.Data, t)
+				}
+			}
+			return resp, nil
+		case ReqQuery:
+			return b.query(req.Query())
+		case ReqTagKeys:
+			return b.tagKeys(), nil
+		case ReqTagValue:
+			return b.tagValues(req.TagValues().Key), nil
+		}
+		return defaultBEHandler(req)
+	}
+}
+
+func (b *FileBackend) query(q *QueryRequest) (Response, error) {
+	var series TimeSeriesResponse
+	var tables TableResponse
+	fromMS, toMS := toMS(q.Range.From), toMS(q.Range.To)
+	for _, t := range q.Targets {
+		b.lock.RLock()
+		src, ok := b.tables[t.Target]
+		b.lock.RUnlock()
+		if !ok {
+			return InvalidData{}, fmt.Errorf("file backend: unknown target %q", t.Target)
+		}
+		td := NewTableData(len(src.Columns))
+		td.Columns = append(td.Columns, src.Columns...)
+		timeCol := -1
+		for i, c := range src.Columns {
+			if c.Text == b.TimeColumn {
+				timeCol = i
+			}
+		}
+		for _, row := range src.Rows {
+			if timeCol >= 0 && !q.Range.From.IsZero() {
+				ts, ok := row[timeCol].(int64)
+				if !ok || ts < fromMS || ts > toMS {
+					continue
+				}
+			}
+			td.InsertRow(row...)
+		}
+		if t.Type == TargetTable {
+			tables.Data = append(tables.Data, td)
+			continue
+		}
+		data, err := TableToSeries(td)
+		if err != nil {
+			return InvalidData{}, fmt.Errorf("file backend: target %q: %v", t.Target, err)
+		}
+		if len(data) == 1 && len(data[0].Labels) == 0 {
+			data[0].Target = t.Target
+		}
+		series.Data = append(series.Data, q.Process(&t, data)...)
+	}
+	if len(tables.Data) > 0 {
+		if len(series.Data) > 0 {
+			tables.Data = append(tables.Data, SeriesToWideTable(series.Data))
+		}
+		return tables, nil
+	}
+	return series, nil
+}
+
+func (b *FileBackend) tagKeys() TagKeysResp {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	keys := make(map[string]TagKey)
+	for _, td := range b.tables {
+		for _, c := range td.Columns {
+			keys[c.Text] = c
+		}
+	}
+	resp := TagKeysResp{Data: make([]TagKey, 0, len(keys))}
+	for _, k := range keys {
+		resp.Data = append(resp.Data, k)
+	}
+	sort.Slice(resp.Data, func(i, j int) bool { return resp.Data[i].Text < resp.Data[j].Text })
+	return resp
+}
+
+func (b *FileBackend) tagValues(key string) TagValuesResp {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	values := make(map[string]bool)
+	for _, td := range b.tables {
+		for i, c := range td.Columns {
+			if c.Text != key {
+				continue
+			}
+			for _, row := range td.Rows {
+				if row[i] != nil {
+					values[cast.ToString(row[i])] = true
+				}
+			}
+		}
+	}
+	resp := TagValuesResp{Data: make([]TagValue, 0, len(values))}
+	for v := range values {
+		resp.Data = append(resp.Data, TagValue{Text: v})
+	}
+	sort.Slice(resp.Data, func(i, j int) bool { return resp.Data[i].Text < resp.Data[j].Text })
+	return resp
+}
+
+// loadFile reads the file into records and builds a typed TableData.
+func (b *FileBackend) loadFile(path, ext string) (TableData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return TableData{}, err
+	}
+	defer f.Close()
+	var columns []string
+	var records [][]interface{}
+	switch ext {
+	case FileCSV:
+		lines, err := csv.NewReader(f).ReadAll()
+		if err != nil {
+			return TableData{}, err
+		}
+		if len(lines) == 0 {
+			return TableData{}, fmt.Errorf("missing header row")
+		}
+		columns = lines[0]
+		for _, line := range lines[1:] {
+			rec := make([]interface{}, len(line))
+			for i, v := range line {
+				rec[i] = v
+			}
+			records = append(records, rec)
+		}
+	case FileJSON:
+		var objs []map[string]interface{}
+		if err := json.NewDecoder(f).Decode(&objs); err != nil {
+			return TableData{}, err
+		}
+		columns, records = objectRecords(objs)
+	case FileJSONL:
+		var objs []map[string]interface{}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == `` {
+				continue
+			}
+			var obj map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &obj); err != nil {
+				return TableData{}, fmt.Errorf("line %d: %v", line, err)
+			}
+			objs = append(objs, obj)
+		}
+		if err := scanner.Err(); err != nil {
+			return TableData{}, err
+		}
+		columns, records = objectRecords(objs)
+	}
+	return b.typedTable(columns, records)
+}
+
+// typedTable infers the column KeyTypes and converts the record values accordingly.
+// Columns holding JSON booleans are string columns.
+func (b *FileBackend) typedTable(columns []string, records [][]interface{}) (TableData, error) {
+	td := NewTableData(len(columns))
+	types := make([]KeyType, len(columns))
+	for i, c := range columns {
+		types[i] = KeyTypeNumber
+		if c == b.TimeColumn {
+			types[i] = KeyTypeTime
+		}
+		for _, rec := range records {
+			if types[i] != KeyTypeNumber || i >= len(rec) || rec[i] == nil || rec[i] == `` {
+				continue
+			}
+			if _, ok := rec[i].(bool); ok {
+				types[i] = KeyTypeString
+			} else if _, err := cast.ToFloat64E(rec[i]); err != nil {
+				types[i] = KeyTypeString
+			}
+		}
+		td.InsertColumn(c, string(types[i]))
+	}
+	for n, rec := range records {
+		row := make([]interface{}, len(columns))
+		for i := range columns {
+			if i >= len(rec) || rec[i] == nil || rec[i] == `` {
+				continue
+			}
+			switch types[i] {
+			case KeyTypeTime:
+				ts, err := parseFileTime(rec[i])
+				if err != nil {
+					return td, fmt.Errorf("row %d: invalid time: %v", n+1, err)
+				}
+				row[i] = ts
+			case KeyTypeNumber:
+				row[i] = cast.ToFloat64(rec[i])
+			default:
+				row[i] = cast.ToString(rec[i])
+			}
+		}
+		td.InsertRow(row...)
+	}
+	return td, nil
+}
+
+// objectRecords returns the union of object keys, sorted, and the values of each object as records.
+func objectRecords(objs []map[string]interface{}) ([]string, [][]interface{}) {
+	keys := make(Labels)
+	for _, obj := range objs {
+		for k := range obj {
+			keys[k] = ``
+		}
+	}
+	columns := keys.Keys()
+	records := make([][]interface{}, 0, len(objs))
+	for _, obj := range objs {
+		rec := make([]interface{}, len(columns))
+		for i, c := range columns {
+			rec[i] = obj[c]
+		}
+		records = append(records, rec)
+	}
+	return columns, records
+}
+
+// parseFileTime parses RFC3339 strings and unix timestamps in seconds or milliseconds into unix milliseconds.
+func parseFileTime(v interface{}) (int64, error) {
+	if s, ok := v.(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return toMS(t), nil
+		}
+	}
+	f, err := cast.ToFloat64E(v)
+	if err != nil {
+		return 0, err
+	}
+	// Timestamps below 1e11 are taken as seconds, i.e. before 1973 in milliseconds.
+	if f < 1e11 {
+		return int64(f * 1000), nil
+	}
+	return int64(f), nil
+}
@@ -0,0 +1,76 @@
+package jsonds
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileBackend(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		`cpu.csv`:    "time,host,value\n1000,h1,1\n1060,h1,2\n1000,h2,3\n5000,h1,4\n",
+		`cpu.json`:   `[{"time": "1970-01-01T00:16:40Z", "value": 5}]`,
+		`mem.jsonl`:  "{\"time\": 1000, \"used\": 10, \"swap\": false}\n\n{\"time\": 1060, \"used\": 20, \"swap\": true}\n",
+		`ignore.txt`: `ignored`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := NewFileBackend(dir, `time`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.Targets(), []string{`cpu.csv`, `cpu.json`, `mem`}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Targets = %v, want %v", got, want)
+	}
+	handler := b.BEHandler()
+	rng := Range{From: time.Unix(1000, 0), To: time.Unix(2000, 0)}
+	tests := []struct {
+		target string
+		want   []TimeSeriesData
+	}{
+		{`cpu.csv`, []TimeSeriesData{
+			{Target: `value {host="h1"}`, Labels: Labels{`host`: `h1`}, Datapoints: []Datapoint{{1, 1000000}, {2, 1060000}}},
+			{Target: `value {host="h2"}`, Labels: Labels{`host`: `h2`}, Datapoints: []Datapoint{{3, 1000000}}},
+		}},
+		{`cpu.json`, []TimeSeriesData{
+			{Target: `cpu.json`, Labels: Labels{}, Datapoints: []Datapoint{{5, 1000000}}},
+		}},
+		{`mem`, []TimeSeriesData{
+			{Target: `used {swap="false"}`, Labels: Labels{`swap`: `false`}, Datapoints: []Datapoint{{10, 1000000}}},
+			{Target: `used {swap="true"}`, Labels: Labels{`swap`: `true`}, Datapoints: []Datapoint{{20, 1060000}}},
+		}},
+	}
+	for _, tt := range tests {
+		resp, err := handler(&QueryRequest{Range: rng, Targets: []Target{{Target: tt.target}}})
+		if err != nil {
+			t.Errorf("%v: %v", tt.target, err)
+			continue
+		}
+		if got := resp.(TimeSeriesResponse).Data; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v = %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+	resp, err := handler(&TagValuesReq{Key: `host`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.(TagValuesResp).Data; !reflect.DeepEqual(got, []TagValue{{`h1`}, {`h2`}}) {
+		t.Errorf("TagValues = %v", got)
+	}
+
+	if err := os.Remove(filepath.Join(dir, `cpu.json`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.Targets(), []string{`cpu`, `mem`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Targets after removal = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,569 @@
+package jsonds
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PathPattern is a compiled Graphite-style target path, a dotted hierarchy of nodes such as
+// kafka.cluster1.topicA.lag. Nodes can contain the following globs:
+//
+//	?         a single character within the node
+//	*         any characters within the node
+//	[0-9]     a character class, [!0-9] negates it
+//	{a,b}     any of the comma separated alternatives, which can contain globs
+type PathPattern struct {
+	Pattern string
+	nodes   []*regexp.Regexp
+}
+
+// ParsePath compiles the given Graphite-style path pattern.
+func ParsePath(pattern string) (*PathPattern, error) {
+	nodes, err := splitPath(pattern)
+	if err != nil {
+		return nil, err
+	}
+	p := &PathPattern{
+		Pattern: pattern,
+		nodes:   make([]*regexp.Regexp, len(nodes)),
+	}
+	for i, n := range nodes {
+		expr, err := globToRegexp(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q: %v", pattern, err)
+		}
+		if p.nodes[i], err = regexp.Compile(`^` + expr + `$`); err != nil {
+			return nil, fmt.Errorf("invalid path %q: %v", pattern, err)
+		}
+	}
+	return p, nil
+}
+
+// IsPathPattern returns true if the given path contains globs.
+func IsPathPattern(path string) bool {
+	return strings.ContainsAny(path, `*?[{`)
+}
+
+// Depth returns the number of nodes of the PathPattern.
+func (p *PathPattern) Depth() int {
+	return len(p.nodes)
+}
+
+// Match returns true if the path has the same depth as the PathPattern and all its nodes match.
+func (p *PathPattern) Match(path string) bool {
+	nodes := strings.Split(path, `.`)
+	return len(nodes) == len(p.nodes) && p.matchNodes(nodes)
+}
+
+// MatchPrefix returns the leading nodes of the path matching the PathPattern, and whether the path
+// has more nodes than the PathPattern.
+func (p *PathPattern) MatchPrefix(path string) (prefix string, branch bool, ok bool) {
+	nodes := strings.Split(path, `.`)
+	if len(nodes) < len(p.nodes) || !p.matchNodes(nodes[:len(p.nodes)]) {
+		return ``, false, false
+	}
+	return strings.Join(nodes[:len(p.nodes)], `.`), len(nodes) > len(p.nodes), true
+}
+
+func (p *PathPattern) matchNodes(nodes []string) bool {
+	for i, re := range p.nodes {
+		if !re.MatchString(nodes[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPath splits the path into nodes on dots outside of braces and brackets.
+func splitPath(path string) ([]string, error) {
+	if path == `` {
+		return nil, fmt.Errorf("empty path")
+	}
+	var nodes []string
+	var braces, start int
+	var bracket bool
+	for i, c := range path {
+		switch {
+		case bracket:
+			if c == ']' {
+				bracket = false
+			}
+		case c == '[':
+			bracket = true
+		case c == '{':
+			braces++
+		case c == '}':
+			if braces == 0 {
+				return nil, fmt.Errorf("invalid path %q: unexpected }", path)
+			}
+			braces--
+		case c == '.' && braces == 0:
+			nodes = append(nodes, path[start:i])
+			start = i + 1
+		}
+	}
+	if bracket || braces > 0 {
+		return nil, fmt.Errorf("invalid path %q: unclosed [ or {", path)
+	}
+	nodes = append(nodes, path[start:])
+	for _, n := range nodes {
+		if n == `` {
+			return nil, fmt.Errorf("invalid path %q: empty node", path)
+		}
+	}
+	return nodes, nil
+}
+
+// globToRegexp translates a path node glob into a regular expression.
+func globToRegexp(glob string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(`[^.]*`)
+		case '?':
+			b.WriteString(`[^.]`)
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return ``, fmt.Errorf("unclosed [")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, `!`) {
+				class = `^` + class[1:]
+			}
+			b.WriteString(`[` + strings.ReplaceAll(class, `\`, `\\`) + `]`)
+			i += end + 1
+		case '{':
+			end, alts, err := splitAlternatives(glob[i:])
+			if err != nil {
+				return ``, err
+			}
+			exprs := make([]string, len(alts))
+			for j, alt := range alts {
+				if exprs[j], err = globToRegexp(alt); err != nil {
+					return ``, err
+				}
+			}
+			b.WriteString(`(?:` + strings.Join(exprs, `|`) + `)`)
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
+
+// splitAlternatives splits a {a,b} group at the start of s, returning the index of the closing brace.
+func splitAlternatives(s string) (int, []string, error) {
+	var alts []string
+	depth, start := 0, 1
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, append(alts, s[start:i]), nil
+			}
+		case ',':
+			if depth == 1 {
+				alts = append(alts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return 0, nil, fmt.Errorf("unclosed {")
+}
+
+// PathFetcher returns the TimeSeriesData of the given path for the QueryRequest.
+type PathFetcher func(req Request, path string) (TimeSeriesData, error)
+
+// PathIndex holds the known target paths for answering Search and Query Requests with Graphite-style paths.
+//
+// Search expands the tree one level at a time: the SearchRequest target is a path pattern whose depth
+// selects the level returned, so an empty target lists the root nodes, kafka.* the children of kafka, and
+// a trailing dot as in kafka. is the same as kafka.*.
+//
+// Query targets are path patterns, expanded into one series per matching path, optionally wrapped in
+// functions, e.g. sumSeries(kafka.*.lag) or aliasByNode(kafka.{cluster1,cluster2}.*.lag, 1, 2).
+// The following functions are supported:
+//
+//	sumSeries, averageSeries, minSeries, maxSeries    aggregate all series into one, aligned by timestamp
+//	scale(series, factor), offset(series, amount)     multiply or add a constant to all values
+//	alias(series, "name")                             rename the series
+//	aliasByNode(series, n...)                         rename the series by the given (0-based) path nodes
+type PathIndex struct {
+	paths map[string]struct{}
+	lock  sync.RWMutex
+}
+
+// NewPathIndex returns a new PathIndex holding the given paths.
+func NewPathIndex(paths ...string) *PathIndex {
+	x := &PathIndex{
+		paths: make(map[string]struct{}),
+	}
+	x.Add(paths...)
+	return x
+}
+
+// Add adds the given paths to the PathIndex.
+func (x *PathIndex) Add(paths ...string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	for _, p := range paths {
+		x.paths[p] = struct{}{}
+	}
+}
+
+// Remove removes the given paths from the PathIndex.
+func (x *PathIndex) Remove(paths ...string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	for _, p := range paths {
+		delete(x.paths, p)
+	}
+}
+
+// Find returns the sorted paths fully matching the given pattern.
+func (x *PathIndex) Find(pattern string) ([]string, error) {
+	p, err := ParsePath(pattern)
+	if err != nil {
+		return nil, err
+	}
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	var found []string
+	for path := range x.paths {
+		if p.Match(path) {
+			found = append(found, path)
+		}
+	}
+	sort.Strings(found)
+	return found, nil
+}
+
+// Expand returns the sorted nodes at the depth of the given pattern which match it, leaves and branches alike.
+func (x *PathIndex) Expand(pattern string) ([]string, error) {
+	switch {
+	case pattern == ``:
+		pattern = `*`
+	case strings.HasSuffix(pattern, `.`):
+		pattern += `*`
+	}
+	p, err := ParsePath(pattern)
+	if err != nil {
+		return nil, err
+	}
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	seen := make(map[string]struct{})
+	var nodes []string
+	for path := range x.paths {
+		if prefix, _, ok := p.MatchPrefix(path); ok {
+			if _, ok := seen[prefix]; !ok {
+				seen[prefix] = struct{}{}
+				nodes = append(nodes, prefix)
+			}
+		}
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// BEHandler returns a BEHandler answering Search and Query Requests, fetching the series of each
+// matching path using the given PathFetcher.
+func (x *PathIndex) BEHandler(fetch PathFetcher) BEHandler {
+	return func(req Request) (Response, error) {
+		switch req.ReqType() {
+		case ReqSearch:
+			nodes, err := x.Expand(req.Search().Target)
+			if err != nil {
+				return InvalidData{}, err
+			}
+			return SearchResponse{Data: nodes}, nil
+		case ReqQuery:
+			q := req.Query()
+			var resp TimeSeriesResponse
+			for _, t := range q.Targets {
+				expr, err := ParseSeriesExpr(t.Target)
+				if err != nil {
+					return InvalidData{}, err
+				}
+				data, err := x.eval(req, expr, fetch)
+				if err != nil {
+					return InvalidData{}, fmt.Errorf("target %q: %v", t.Target, err)
+				}
+				resp.Data = append(resp.Data, q.Process(&t, data)...)
+			}
+			return resp, nil
+		}
+		return defaultBEHandler(req)
+	}
+}
+
+// SeriesExpr is a parsed query target: a path pattern, a string or number argument, or a function call.
+type SeriesExpr struct {
+	Text string
+	Path string
+	Func string
+	Args []SeriesExpr
+
+	Str    string
+	Num    float64
+	IsStr  bool
+	IsNum  bool
+	IsCall bool
+}
+
+// ParseSeriesExpr parses a query target such as sumSeries(kafka.*.lag).
+func ParseSeriesExpr(target string) (SeriesExpr, error) {
+	p := exprParser{s: strings.TrimSpace(target)}
+	e, err := p.parse()
+	if err != nil {
+		return SeriesExpr{}, fmt.Errorf("invalid target %q: %v", target, err)
+	}
+	if p.i != len(p.s) {
+		return SeriesExpr{}, fmt.Errorf("invalid target %q: unexpected %q at %d", target, p.s[p.i:], p.i)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	s string
+	i int
+}
+
+func (p *exprParser) skipSpace() {
+	for p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *exprParser) parse() (SeriesExpr, error) {
+	p.skipSpace()
+	start := p.i
+	if p.i < len(p.s) && (p.s[p.i] == '"' || p.s[p.i] == '\'') {
+		quote := p.s[p.i]
+		end := strings.IndexByte(p.s[p.i+1:], quote)
+		if end < 0 {
+			return SeriesExpr{}, fmt.Errorf("unclosed string at %d", start)
+		}
+		p.i += end + 2
+		return SeriesExpr{Text: p.s[start:p.i], Str: p.s[start+1 : p.i-1], IsStr: true}, nil
+	}
+	var braces int
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		if braces == 0 && (c == '(' || c == ',' || c == ')') {
+			break
+		}
+		switch c {
+		case '{':
+			braces++
+		case '}':
+			braces--
+		}
+		p.i++
+	}
+	token := strings.TrimSpace(p.s[start:p.i])
+	if token == `` {
+		return SeriesExpr{}, fmt.Errorf("expected expression at %d", start)
+	}
+	if p.i < len(p.s) && p.s[p.i] == '(' {
+		e := SeriesExpr{Func: token, IsCall: true}
+		p.i++
+		for {
+			arg, err := p.parse()
+			if err != nil {
+				return SeriesExpr{}, err
+			}
+			e.Args = append(e.Args, arg)
+			p.skipSpace()
+			if p.i >= len(p.s) {
+				return SeriesExpr{}, fmt.Errorf("unclosed ( at %d", start)
+			}
+			if p.s[p.i] == ')' {
+				p.i++
+				break
+			}
+			if p.s[p.i] != ',' {
+				return SeriesExpr{}, fmt.Errorf("unexpected %q at %d", p.s[p.i], p.i)
+			}
+			p.i++
+		}
+		e.Text = p.s[start:p.i]
+		p.skipSpace()
+		return e, nil
+	}
+	if n, err := strconv.ParseFloat(token, 64); err == nil {
+		return SeriesExpr{Text: token, Num: n, IsNum: true}, nil
+	}
+	return SeriesExpr{Text: token, Path: token}, nil
+}
+
+// eval evaluates the SeriesExpr into TimeSeriesData.
+func (x *PathIndex) eval(req Request, e SeriesExpr, fetch PathFetcher) ([]TimeSeriesData, error) {
+	switch {
+	case e.IsStr || e.IsNum:
+		return nil, fmt.Errorf("expected series, got %v", e.Text)
+	case !e.IsCall:
+		paths := []string{e.Path}
+		if IsPathPattern(e.Path) {
+			var err error
+			if paths, err = x.Find(e.Path); err != nil {
+				return nil, err
+			}
+		}
+		data := make([]TimeSeriesData, 0, len(paths))
+		for _, p := range paths {
+			ts, err := fetch(req, p)
+			if err != nil {
+				return nil, fmt.Errorf("path %q: %v", p, err)
+			}
+			if ts.Target == `` {
+				ts.Target = p
+			}
+			data = append(data, ts)
+		}
+		return data, nil
+	}
+	if len(e.Args) == 0 {
+		return nil, fmt.Errorf("%v: missing series argument", e.Func)
+	}
+	var data []TimeSeriesData
+	args := e.Args
+	if reduce, ok := seriesReducers[e.Func]; ok {
+		for _, a := range args {
+			d, err := x.eval(req, a, fetch)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, d...)
+		}
+		return []TimeSeriesData{reduceSeries(e.Text, data, reduce)}, nil
+	}
+	data, err := x.eval(req, args[0], fetch)
+	if err != nil {
+		return nil, err
+	}
+	args = args[1:]
+	switch e.Func {
+	case `scale`, `offset`:
+		if len(args) != 1 || !args[0].IsNum {
+			return nil, fmt.Errorf("%v: expected a number argument", e.Func)
+		}
+		for i := range data {
+			// Copy the Datapoints as the PathFetcher may return its own.
+			dps := make([]Datapoint, len(data[i].Datapoints))
+			for j, dp := range data[i].Datapoints {
+				if e.Func == `scale` {
+					dp.MetricValue *= args[0].Num
+				} else {
+					dp.MetricValue += args[0].Num
+				}
+				dps[j] = dp
+			}
+			data[i].Datapoints = dps
+			data[i].Target = fmt.Sprintf("%v(%v,%v)", e.Func, data[i].Target, args[0].Text)
+		}
+	case `alias`:
+		if len(args) != 1 || !args[0].IsStr {
+			return nil, fmt.Errorf("alias: expected a string argument")
+		}
+		for i := range data {
+			data[i].Target = args[0].Str
+		}
+	case `aliasByNode`:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("aliasByNode: expected node arguments")
+		}
+		for i := range data {
+			nodes := strings.Split(seriesPath(data[i].Target), `.`)
+			parts := make([]string, 0, len(args))
+			for _, a := range args {
+				n := int(a.Num)
+				if n < 0 {
+					n += len(nodes)
+				}
+				if !a.IsNum || n < 0 || n >= len(nodes) {
+					return nil, fmt.Errorf("aliasByNode: invalid node %v for %q", a.Text, data[i].Target)
+				}
+				parts = append(parts, nodes[n])
+			}
+			data[i].Target = strings.Join(parts, `.`)
+		}
+	default:
+		return nil, fmt.Errorf("unknown function %q", e.Func)
+	}
+	return data, nil
+}
+
+// seriesPath returns the innermost path of a series name, e.g. a.b.c for scale(a.b.c,2).
+func seriesPath(name string) string {
+	if i := strings.LastIndexByte(name, '('); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexAny(name, `,)`); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// seriesReducers are the functions aggregating the values of series sharing a timestamp.
+var seriesReducers = map[string]func(values []float64) float64{
+	`sumSeries`: func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	`averageSeries`: func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	`minSeries`: func(values []float64) float64 {
+		min := math.Inf(1)
+		for _, v := range values {
+			min = math.Min(min, v)
+		}
+		return min
+	},
+	`maxSeries`: func(values []float64) float64 {
+		max := math.Inf(-1)
+		for _, v := range values {
+			max = math.Max(max, v)
+		}
+		return max
+	},
+}
+
+// reduceSeries aggregates the Datapoints of all series sharing a timestamp into a single series.
+func reduceSeries(name string, data []TimeSeriesData, reduce func([]float64) float64) TimeSeriesData {
+	values := make(map[int64][]float64)
+	for _, d := range data {
+		for _, dp := range d.Datapoints {
+			values[dp.UnixTimestampMS] = append(values[dp.UnixTimestampMS], dp.MetricValue)
+		}
+	}
+	timestamps := make([]int64, 0, len(values))
+	for ts := range values {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	out := TimeSeriesData{Target: name}
+	for _, ts := range timestamps {
+		out.AddDataPoint(reduce(values[ts]), ts)
+	}
+	return out
+}
@@ -0,0 +1,107 @@
+package jsonds
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPathPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{`kafka.cluster1.lag`, `kafka.cluster1.lag`, true},
+		{`kafka.*.lag`, `kafka.cluster1.lag`, true},
+		{`kafka.*.lag`, `kafka.cluster1.topicA.lag`, false},
+		{`kafka.*`, `kafka.cluster1.lag`, false},
+		{`kafka.cluster?.lag`, `kafka.cluster1.lag`, true},
+		{`kafka.cluster?.lag`, `kafka.cluster10.lag`, false},
+		{`kafka.cluster[0-9].lag`, `kafka.cluster1.lag`, true},
+		{`kafka.cluster[!0-9].lag`, `kafka.cluster1.lag`, false},
+		{`kafka.cluster[!0-9].lag`, `kafka.clusterA.lag`, true},
+		{`kafka.{cluster1,cluster2}.lag`, `kafka.cluster2.lag`, true},
+		{`kafka.{cluster1,cluster2}.lag`, `kafka.cluster3.lag`, false},
+		{`kafka.{cluster*,broker[0-9]}.lag`, `kafka.broker1.lag`, true},
+		{`kafka.{a.b,c}.lag`, `kafka.a.b.lag`, false},
+		{`kafka.c+.lag`, `kafka.c+.lag`, true},
+		{`kafka.c+.lag`, `kafka.cc.lag`, false},
+	}
+	for _, tt := range tests {
+		p, err := ParsePath(tt.pattern)
+		if err != nil {
+			t.Errorf("ParsePath(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := p.Match(tt.path); got != tt.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParsePathErrors(t *testing.T) {
+	for _, pattern := range []string{``, `a..b`, `a.`, `a.[0-9`, `a.{b,c`, `a.b}`} {
+		if _, err := ParsePath(pattern); err == nil {
+			t.Errorf("ParsePath(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestPathIndex(t *testing.T) {
+	x := NewPathIndex(`kafka.c1.topicA.lag`, `kafka.c1.topicB.lag`, `kafka.c2.topicA.lag`, `zk.latency`)
+	expand := []struct {
+		pattern string
+		want    []string
+	}{
+		{``, []string{`kafka`, `zk`}},
+		{`kafka.`, []string{`kafka.c1`, `kafka.c2`}},
+		{`kafka.*.topicA`, []string{`kafka.c1.topicA`, `kafka.c2.topicA`}},
+		{`kafka.c{1,3}.*.lag`, []string{`kafka.c1.topicA.lag`, `kafka.c1.topicB.lag`}},
+	}
+	for _, tt := range expand {
+		got, err := x.Expand(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Expand(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+
+	stored := map[string][]Datapoint{
+		`kafka.c1.topicA.lag`: {{1, 1000}, {2, 2000}},
+		`kafka.c2.topicA.lag`: {{3, 1000}},
+	}
+	fetch := func(req Request, path string) (TimeSeriesData, error) {
+		return TimeSeriesData{Datapoints: stored[path]}, nil
+	}
+	handler := x.BEHandler(fetch)
+	tests := []struct {
+		target string
+		want   []TimeSeriesData
+	}{
+		{`kafka.*.topicA.lag`, []TimeSeriesData{
+			{Target: `kafka.c1.topicA.lag`, Datapoints: []Datapoint{{1, 1000}, {2, 2000}}},
+			{Target: `kafka.c2.topicA.lag`, Datapoints: []Datapoint{{3, 1000}}},
+		}},
+		{`aliasByNode(scale(kafka.*.topicA.lag, 10), 1)`, []TimeSeriesData{
+			{Target: `c1`, Datapoints: []Datapoint{{10, 1000}, {20, 2000}}},
+			{Target: `c2`, Datapoints: []Datapoint{{30, 1000}}},
+		}},
+		{`alias(offset(kafka.c2.topicA.lag, 1), "c2 lag")`, []TimeSeriesData{
+			{Target: `c2 lag`, Datapoints: []Datapoint{{4, 1000}}},
+		}},
+	}
+	for _, tt := range tests {
+		resp, err := handler(&QueryRequest{Targets: []Target{{Target: tt.target}}})
+		if err != nil {
+			t.Fatalf("%v: %v", tt.target, err)
+		}
+		if want := (TimeSeriesResponse{Data: tt.want}); !reflect.DeepEqual(resp, want) {
+			t.Errorf("%v: response = %+v, want %+v", tt.target, resp, want)
+		}
+	}
+	if want := []Datapoint{{1, 1000}, {2, 2000}}; !reflect.DeepEqual(stored[`kafka.c1.topicA.lag`], want) {
+		t.Errorf("scale modified the fetched datapoints: %v", stored[`kafka.c1.topicA.lag`])
+	}
+}
@@ -2,10 +2,10 @@ package jsonds
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
-	"github.com/google/logger"
 	"github.com/julienschmidt/httprouter"
 	"github.com/tidwall/pretty"
 	"go.uber.org/zap"
@@ -36,8 +36,13 @@ func defaultBEHandler(req Request) (Response, error) {
 
 func (g *GrafanaBackend) statusOK(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	g.APISrv.Logger.Debug("root endpoint called", zap.String("method", r.Method), zap.String("from", r.RemoteAddr), zap.String("URI", r.RequestURI))
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	report := g.Ready(r.Context())
+	if report.Status != HealthOK {
+		g.APISrv.Logger.Warn("datasource not ready", zap.String("message", report.Message))
+		writeJSONError(w, http.StatusServiceUnavailable, report.Message)
+		return
+	}
+	g.writeJSONResponse(w, http.StatusOK, report)
 }
 
 func (g *GrafanaBackend) handleSearch(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -45,20 +50,7 @@ func (g *GrafanaBackend) handleSearch(w http.ResponseWriter, r *http.Request, _
 	switch r.Method {
 	case http.MethodPost:
 		var req SearchRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			logger.Error("json decode failure", zap.Error(err))
-			errMsg := fmt.Sprintf("json decode failure: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(errMsg))
-			return
-		}
-		resp, err := g.beHandlers[SearchEndpoint](&req)
-		if err != nil {
-			logger.Error("backend handler failer", zap.String("endpoint", string(SearchEndpoint)), zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{\"error\":true,\"message\":\"` + err.Error() + `\"}`))
-		}
-		g.writeJSONResponse(w, http.StatusOK, resp)
+		g.serve(SearchEndpoint, w, r, &req)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("bad method; supported POST"))
@@ -71,20 +63,7 @@ func (g *GrafanaBackend) handleQuery(w http.ResponseWriter, r *http.Request, _ h
 	switch r.Method {
 	case http.MethodPost:
 		var req QueryRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			logger.Error("json decode failure", zap.Error(err))
-			errMsg := fmt.Sprintf("json decode failure: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(errMsg))
-			return
-		}
-		resp, err := g.beHandlers[QueryEndpoint](&req)
-		if err != nil {
-			logger.Error("backend handler failer", zap.String("endpoint", string(QueryEndpoint)), zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{\"error\":true,\"message\":\"` + err.Error() + `\"}`))
-		}
-		g.writeJSONResponse(w, http.StatusOK, resp)
+		g.serve(QueryEndpoint, w, r, &req)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("bad method; supported POST"))
@@ -97,20 +76,7 @@ func (g *GrafanaBackend) handleAnnotations(w http.ResponseWriter, r *http.Reques
 	switch r.Method {
 	case http.MethodPost:
 		var req AnnotationsReq
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			logger.Error("json decode failure", zap.Error(err))
-			errMsg := fmt.Sprintf("json decode failure: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(errMsg))
-			return
-		}
-		resp, err := g.beHandlers[AnnotationsEndpoint](&req)
-		if err != nil {
-			logger.Error("backend handler failer", zap.String("endpoint", string(AnnotationsEndpoint)), zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{\"error\":true,\"message\":\"` + err.Error() + `\"}`))
-		}
-		g.writeJSONResponse(w, http.StatusOK, resp)
+		g.serve(AnnotationsEndpoint, w, r, &req)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("bad method; supported POST"))
@@ -123,20 +89,7 @@ func (g *GrafanaBackend) handleTagKeys(w http.ResponseWriter, r *http.Request, _
 	switch r.Method {
 	case http.MethodPost:
 		var req TagKeysReq
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			logger.Error("json decode failure", zap.Error(err))
-			errMsg := fmt.Sprintf("json decode failure: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(errMsg))
-			return
-		}
-		resp, err := g.beHandlers[TagKeysEndpoint](&req)
-		if err != nil {
-			logger.Error("backend handler failer", zap.String("endpoint", string(TagKeysEndpoint)), zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{\"error\":true,\"message\":\"` + err.Error() + `\"}`))
-		}
-		g.writeJSONResponse(w, http.StatusOK, resp)
+		g.serve(TagKeysEndpoint, w, r, &req)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("bad method; supported POST"))
@@ -149,20 +102,7 @@ func (g *GrafanaBackend) handleTagValues(w http.ResponseWriter, r *http.Request,
 	switch r.Method {
 	case http.MethodPost:
 		var req TagValuesReq
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			logger.Error("json decode failure", zap.Error(err))
-			errMsg := fmt.Sprintf("json decode failure: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(errMsg))
-			return
-		}
-		resp, err := g.beHandlers[TagValuesEndpoint](&req)
-		if err != nil {
-			logger.Error("backend handler failer", zap.String("endpoint", string(TagValuesEndpoint)), zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{\"error\":true,\"message\":\"` + err.Error() + `\"}`))
-		}
-		g.writeJSONResponse(w, http.StatusOK, resp)
+		g.serve(TagValuesEndpoint, w, r, &req)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("bad method; supported POST"))
@@ -170,6 +110,58 @@ func (g *GrafanaBackend) handleTagValues(w http.ResponseWriter, r *http.Request,
 	}
 }
 
+// serve decodes the request body into req, passes it to the BEHandler of the Endpoint and writes the JSON response.
+func (g *GrafanaBackend) serve(ep Endpoint, w http.ResponseWriter, r *http.Request, req Request) {
+	_, span := g.tracer.Start(r.Context(), `decode`)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		endSpan(span, err)
+		g.APISrv.Logger.Error("json decode failure", zap.String("endpoint", string(ep)), zap.Error(err))
+		errMsg := fmt.Sprintf("json decode failure: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errMsg))
+		return
+	}
+	span.SetAttributes(requestAttributes(req)...)
+	endSpan(span, nil)
+	setAccessInfo(r.Context(), req)
+	ctx, span := g.tracer.Start(r.Context(), `handler`)
+	resp, err := g.beHandler(ep)(WithContext(req, ctx))
+	if err != nil && resp != nil && resp.RespType() != RespInvalid {
+		// Partial results, e.g. from a ProxyBackend with failed Upstreams, are served with the errors in
+		// Warning headers, one per refId for QueryErrors.
+		endSpan(span, err)
+		g.APISrv.Logger.Warn("backend handler partial failure", zap.String("endpoint", string(ep)), zap.Error(err))
+		var errs QueryErrors
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				w.Header().Add(`Warning`, fmt.Sprintf(`199 - %q`, e.Error()))
+			}
+		} else {
+			w.Header().Add(`Warning`, fmt.Sprintf(`199 - %q`, err.Error()))
+		}
+		_, span = g.tracer.Start(r.Context(), `encode`)
+		g.writeJSONResponse(w, http.StatusOK, resp)
+		endSpan(span, nil)
+		return
+	}
+	if err != nil {
+		endSpan(span, err)
+		g.APISrv.Logger.Error("backend handler failure", zap.String("endpoint", string(ep)), zap.Error(err))
+		var errs QueryErrors
+		if errors.As(err, &errs) {
+			writeJSONErrors(w, http.StatusInternalServerError, errs)
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	span.SetAttributes(responseAttributes(resp)...)
+	endSpan(span, nil)
+	_, span = g.tracer.Start(r.Context(), `encode`)
+	g.writeJSONResponse(w, http.StatusOK, resp)
+	endSpan(span, nil)
+}
+
 // WriteJSONResponse generates a JSON response from the given JSON object and writes to the given ResponseWriter.
 func (g *GrafanaBackend) writeJSONResponse(w http.ResponseWriter, statusCode int, jsonObj interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -182,6 +174,34 @@ func (g *GrafanaBackend) writeJSONResponse(w http.ResponseWriter, statusCode int
 	}
 }
 
+// errorResponse is the JSON body returned for failed requests.
+type errorResponse struct {
+	Error   bool              `json:"error"`
+	Message string            `json:"message"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// writeJSONError writes a JSON error body with the given status code.
+func writeJSONError(w http.ResponseWriter, statusCode int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(errorResponse{
+		Error:   true,
+		Message: msg,
+	})
+}
+
+// writeJSONErrors writes a JSON error body with the given status code, listing the errors by refId.
+func writeJSONErrors(w http.ResponseWriter, statusCode int, errs QueryErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(errorResponse{
+		Error:   true,
+		Message: errs.Error(),
+		Errors:  errs.ByRefID(),
+	})
+}
+
 /*
 type httpResponseRequestInfo struct {
 	URI  string `json:"url"`
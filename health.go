@@ -0,0 +1,154 @@
+package jsonds
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+)
+
+// Health Endpoints defaults. Change according to your specific paths before calling Configure.
+var (
+	// HealthzEndpoint - (GET), used for liveness.
+	HealthzEndpoint Endpoint = `/healthz`
+
+	// ReadyzEndpoint - (GET), used for readiness.
+	ReadyzEndpoint Endpoint = `/readyz`
+)
+
+// HealthCheckTimeout is the time allowed for all health checks to complete.
+var HealthCheckTimeout = 5 * time.Second
+
+// Available health statuses:
+const (
+	HealthOK       = `ok`
+	HealthFailing  = `failing`
+	HealthStopping = `stopping`
+)
+
+// HealthCheck reports an error when a dependency of the GrafanaBackend is unhealthy.
+type HealthCheck func(ctx context.Context) error
+
+// HealthReport is the JSON status report returned by the health Endpoints.
+type HealthReport struct {
+	Status  string                 `json:"status"`
+	Message string                 `json:"message"`
+	Checks  map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the result of a single HealthCheck.
+type CheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"durationMs"`
+}
+
+// RegisterHealthCheck registers a named HealthCheck used for readiness.
+// Registering an existing name replaces the previous HealthCheck.
+func (g *GrafanaBackend) RegisterHealthCheck(name string, check HealthCheck) {
+	g.healthLock.Lock()
+	defer g.healthLock.Unlock()
+	if g.healthChecks == nil {
+		g.healthChecks = make(map[string]HealthCheck)
+	}
+	g.healthChecks[name] = check
+}
+
+// Ready runs all registered HealthChecks concurrently and returns the resulting HealthReport.
+func (g *GrafanaBackend) Ready(ctx context.Context) HealthReport {
+	g.drainLock.Lock()
+	draining := g.draining
+	g.drainLock.Unlock()
+	if draining {
+		return HealthReport{Status: HealthStopping, Message: `server shutting down`}
+	}
+	g.healthLock.RLock()
+	checks := make(map[string]HealthCheck, len(g.healthChecks))
+	for name, check := range g.healthChecks {
+		checks[name] = check
+	}
+	g.healthLock.RUnlock()
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+	report := HealthReport{
+		Status:  HealthOK,
+		Message: `Data source is working`,
+		Checks:  make(map[string]CheckResult, len(checks)),
+	}
+	var failed []string
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := runHealthCheck(ctx, check)
+			result := CheckResult{
+				Status:     HealthOK,
+				DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				result.Status = HealthFailing
+				result.Error = err.Error()
+				failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			}
+			report.Checks[name] = result
+		}(name, check)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		report.Status = HealthFailing
+		report.Message = `not ready: ` + strings.Join(failed, `; `)
+	}
+	return report
+}
+
+// runHealthCheck runs the HealthCheck, returning the context error if it does not return in time.
+func runHealthCheck(ctx context.Context, check HealthCheck) error {
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				result <- fmt.Errorf("health check panic: %v", v)
+			}
+		}()
+		result <- check(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *GrafanaBackend) handleHealthz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	g.drainLock.Lock()
+	draining := g.draining
+	g.drainLock.Unlock()
+	if draining {
+		g.writeJSONResponse(w, http.StatusServiceUnavailable, HealthReport{Status: HealthStopping, Message: `server shutting down`})
+		return
+	}
+	g.writeJSONResponse(w, http.StatusOK, HealthReport{Status: HealthOK, Message: `alive`})
+}
+
+func (g *GrafanaBackend) handleReadyz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	report := g.Ready(r.Context())
+	if report.Status != HealthOK {
+		g.APISrv.Logger.Warn("readiness check failed", zap.String("message", report.Message))
+		g.writeJSONResponse(w, http.StatusServiceUnavailable, report)
+		return
+	}
+	g.writeJSONResponse(w, http.StatusOK, report)
+}
@@ -0,0 +1,103 @@
+package jsonds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	g := New(&Config{Name: `test`})
+	g.APISrv.Logger = zap.NewNop()
+	var ready, failing atomic.Bool
+	g.RegisterHealthCheck(`startup`, func(ctx context.Context) error {
+		if !ready.Load() {
+			return errors.New("starting")
+		}
+		return nil
+	})
+	g.RegisterHealthCheck(`db`, func(ctx context.Context) error {
+		if failing.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	get := func(handle httprouter.Handle) (int, HealthReport) {
+		w := httptest.NewRecorder()
+		handle(w, httptest.NewRequest(http.MethodGet, `/`, nil), nil)
+		var report HealthReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+	tests := []struct {
+		name        string
+		ready       bool
+		failing     bool
+		draining    bool
+		wantHealthz int
+		wantReadyz  int
+		wantRoot    int
+		wantMessage string
+	}{
+		{`not ready`, false, false, false, http.StatusOK, http.StatusServiceUnavailable, http.StatusServiceUnavailable, `not ready: startup: starting`},
+		{`ready`, true, false, false, http.StatusOK, http.StatusOK, http.StatusOK, `Data source is working`},
+		{`failing check`, true, true, false, http.StatusOK, http.StatusServiceUnavailable, http.StatusServiceUnavailable, `not ready: db: connection refused`},
+		{`draining`, true, false, true, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, `server shutting down`},
+	}
+	for _, tt := range tests {
+		ready.Store(tt.ready)
+		failing.Store(tt.failing)
+		g.drainLock.Lock()
+		g.draining = tt.draining
+		g.drainLock.Unlock()
+		if code, _ := get(g.handleHealthz); code != tt.wantHealthz {
+			t.Errorf("%v: /healthz status = %v, want %v", tt.name, code, tt.wantHealthz)
+		}
+		code, report := get(g.handleReadyz)
+		if code != tt.wantReadyz {
+			t.Errorf("%v: /readyz status = %v, want %v", tt.name, code, tt.wantReadyz)
+		}
+		if report.Message != tt.wantMessage {
+			t.Errorf("%v: /readyz message = %q, want %q", tt.name, report.Message, tt.wantMessage)
+		}
+		if !tt.draining && report.Checks[`db`].Status == `` {
+			t.Errorf("%v: /readyz checks = %v, want the db check reported", tt.name, report.Checks)
+		}
+		if code, _ := get(g.statusOK); code != tt.wantRoot {
+			t.Errorf("%v: / status = %v, want %v", tt.name, code, tt.wantRoot)
+		}
+	}
+}
+
+func TestReadyCheckFailures(t *testing.T) {
+	timeout := HealthCheckTimeout
+	HealthCheckTimeout = 20 * time.Millisecond
+	defer func() { HealthCheckTimeout = timeout }()
+	g := New(&Config{Name: `test`})
+	g.RegisterHealthCheck(`panic`, func(ctx context.Context) error {
+		panic("boom")
+	})
+	g.RegisterHealthCheck(`slow`, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	report := g.Ready(context.Background())
+	if report.Status != HealthFailing {
+		t.Fatalf("status = %v, want %v", report.Status, HealthFailing)
+	}
+	if err := report.Checks[`panic`].Error; !strings.Contains(err, `health check panic: boom`) {
+		t.Errorf("panic check error = %q", err)
+	}
+	if err := report.Checks[`slow`].Error; err != context.DeadlineExceeded.Error() {
+		t.Errorf("slow check error = %q, want %q", err, context.DeadlineExceeded)
+	}
+}
@@ -1,13 +1,15 @@
 package jsonds
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/jbvmio/modules/httpserver"
 	"github.com/julienschmidt/httprouter"
 	"github.com/tidwall/pretty"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -18,6 +20,37 @@ type GrafanaBackend struct {
 	APISrv     *httpserver.Module
 	handlers   map[Endpoint]httprouter.Handle
 	beHandlers map[Endpoint]BEHandler
+	// userHandlers holds the BEHandlers set with SetSearch, SetQuery, etc.,
+	// used by Reload for Endpoints without a Handler in the Config.
+	userHandlers map[*Endpoint]BEHandler
+	handlerLock  sync.RWMutex
+	cache        *ResponseCache
+	coalescer    *Coalescer
+	incCache     *IncrementalCache
+	limiter      *Limiter
+	recorder     *Recorder
+	tracer       trace.Tracer
+	accessLog    *AccessLog
+	auth         *BasicAuth
+	store        *MemoryStore
+	labels       *LabelIndex
+
+	panicDumpDir string
+	panics       uint64
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	inflight  sync.WaitGroup
+	drainLock sync.Mutex
+	draining  bool
+
+	healthChecks map[string]HealthCheck
+	healthLock   sync.RWMutex
+
+	config     *Config
+	configLock sync.RWMutex
+	level      zap.AtomicLevel
+	data       map[string]interface{}
 }
 
 // Endpoint represents a Datasource Endpoint Path.
@@ -65,11 +98,23 @@ func New(config *Config) *GrafanaBackend {
 	httpserver.LogLevel = config.LogLevel
 	httpConfigs.Server[config.Name] = httpConfig
 	apiSrv := httpserver.NewModule(&httpConfigs)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	level := zap.NewAtomicLevel()
+	level.UnmarshalText([]byte(config.LogLevel))
 
 	return &GrafanaBackend{
-		APISrv:     apiSrv,
-		handlers:   make(map[Endpoint]httprouter.Handle, len(Endpoints)),
-		beHandlers: make(map[Endpoint]BEHandler, len(Endpoints)),
+		APISrv:       apiSrv,
+		handlers:     make(map[Endpoint]httprouter.Handle, len(Endpoints)),
+		beHandlers:   make(map[Endpoint]BEHandler, len(Endpoints)),
+		userHandlers: make(map[*Endpoint]BEHandler, len(Endpoints)),
+		tracer:       noopTracer(),
+		labels:       NewLabelIndex(),
+		ctx:          ctx,
+		cancel:       cancel,
+		config:       config,
+		level:        level,
+		data:         config.Data,
 	}
 }
 
@@ -79,6 +124,12 @@ func (g *GrafanaBackend) Logger(name string) *zap.Logger {
 	return logger
 }
 
+// Labels returns the LabelIndex holding the labels of all query results.
+// TagKeys and TagValues Requests are answered from it unless their BEHandlers are set.
+func (g *GrafanaBackend) Labels() *LabelIndex {
+	return g.labels
+}
+
 // SetRoot configures the Root Endpoint Path.
 func (g *GrafanaBackend) SetRoot(path string) {
 	delete(g.beHandlers, RootEndpoint)
@@ -90,6 +141,7 @@ func (g *GrafanaBackend) SetSearch(path string, handler BEHandler) {
 	delete(g.beHandlers, SearchEndpoint)
 	SearchEndpoint = Endpoint(path)
 	g.beHandlers[SearchEndpoint] = handler
+	g.userHandlers[&SearchEndpoint] = handler
 }
 
 // SetQuery configures the Query Endpoint with the corresponding Path and BEHandler.
@@ -97,6 +149,7 @@ func (g *GrafanaBackend) SetQuery(path string, handler BEHandler) {
 	delete(g.beHandlers, QueryEndpoint)
 	QueryEndpoint = Endpoint(path)
 	g.beHandlers[QueryEndpoint] = handler
+	g.userHandlers[&QueryEndpoint] = handler
 }
 
 // SetAnnotations configures the Annotations Endpoint with the corresponding Path and BEHandler.
@@ -104,6 +157,7 @@ func (g *GrafanaBackend) SetAnnotations(path string, handler BEHandler) {
 	delete(g.beHandlers, AnnotationsEndpoint)
 	AnnotationsEndpoint = Endpoint(path)
 	g.beHandlers[AnnotationsEndpoint] = handler
+	g.userHandlers[&AnnotationsEndpoint] = handler
 }
 
 // SetTagKeys configures the TagKeys Endpoint with the corresponding Path and BEHandler.
@@ -111,6 +165,7 @@ func (g *GrafanaBackend) SetTagKeys(path string, handler BEHandler) {
 	delete(g.beHandlers, TagKeysEndpoint)
 	TagKeysEndpoint = Endpoint(path)
 	g.beHandlers[TagKeysEndpoint] = handler
+	g.userHandlers[&TagKeysEndpoint] = handler
 }
 
 // SetTagValues configures the TagValues Endpoint with the corresponding Path and BEHandler.
@@ -118,6 +173,73 @@ func (g *GrafanaBackend) SetTagValues(path string, handler BEHandler) {
 	delete(g.beHandlers, TagValuesEndpoint)
 	TagValuesEndpoint = Endpoint(path)
 	g.beHandlers[TagValuesEndpoint] = handler
+	g.userHandlers[&TagValuesEndpoint] = handler
+}
+
+// SetCache enables the given ResponseCache in front of all configured BEHandlers.
+// Must be called before Configure.
+func (g *GrafanaBackend) SetCache(cache *ResponseCache) {
+	g.cache = cache
+}
+
+// SetCoalescer enables the given Coalescer in front of all configured BEHandlers.
+// Must be called before Configure.
+func (g *GrafanaBackend) SetCoalescer(coalescer *Coalescer) {
+	g.coalescer = coalescer
+}
+
+// SetIncrementalCache enables the given IncrementalCache in front of the Query BEHandler.
+// Must be called before Configure.
+func (g *GrafanaBackend) SetIncrementalCache(cache *IncrementalCache) {
+	g.incCache = cache
+}
+
+// SetLimiter enables the given Limiter on all Endpoints except the Root Endpoint.
+// Must be called before Configure.
+func (g *GrafanaBackend) SetLimiter(limiter *Limiter) {
+	g.limiter = limiter
+}
+
+// SetRecorder enables recording of all requests with the given Recorder.
+// Must be called before Configure.
+func (g *GrafanaBackend) SetRecorder(recorder *Recorder) {
+	g.recorder = recorder
+}
+
+// SetAccessLog enables access logging of all requests with the given AccessLog.
+// Must be called before Configure.
+func (g *GrafanaBackend) SetAccessLog(accessLog *AccessLog) {
+	g.accessLog = accessLog
+}
+
+// SetWrite configures the Write Endpoint with the corresponding Path, writing to the given MemoryStore.
+func (g *GrafanaBackend) SetWrite(path string, store *MemoryStore) {
+	WriteEndpoint = Endpoint(path)
+	g.store = store
+}
+
+// SetBasicAuth requires HTTP basic auth on all Endpoints except the health Endpoints.
+// Must be called before Configure.
+func (g *GrafanaBackend) SetBasicAuth(auth *BasicAuth) {
+	g.auth = auth
+}
+
+// handle applies the configured middleware to the handle of the given Endpoint.
+func (g *GrafanaBackend) handle(ep Endpoint, handle httprouter.Handle) httprouter.Handle {
+	if g.auth != nil {
+		handle = g.auth.Wrap(handle)
+	}
+	if g.limiter != nil && ep != RootEndpoint {
+		handle = g.limiter.Wrap(ep, handle)
+	}
+	handle = g.traceRequest(ep, handle)
+	if g.recorder != nil {
+		handle = g.recorder.Wrap(ep, handle)
+	}
+	if g.accessLog != nil {
+		handle = g.accessLog.Wrap(ep, handle)
+	}
+	return g.track(handle)
 }
 
 // Configure sets all configurations.
@@ -136,21 +258,95 @@ func (g *GrafanaBackend) Configure() {
 			default:
 				defaultHandler[string(*ep)] = false
 			}
+			if defaultHandler[string(*ep)] && (*ep == TagKeysEndpoint || *ep == TagValuesEndpoint) {
+				g.beHandlers[*ep] = g.labels.BEHandler()
+			}
 		}
 	}
-	g.APISrv.GET(string(RootEndpoint), g.statusOK)
-	g.APISrv.POST(string(SearchEndpoint), g.handleSearch)
-	g.APISrv.POST(string(QueryEndpoint), g.handleQuery)
-	g.APISrv.POST(string(AnnotationsEndpoint), g.handleAnnotations)
-	g.APISrv.POST(string(TagKeysEndpoint), g.handleTagKeys)
-	g.APISrv.POST(string(TagValuesEndpoint), g.handleTagValues)
+	g.APISrv.GET(string(RootEndpoint), g.handle(RootEndpoint, g.statusOK))
+	g.APISrv.GET(string(HealthzEndpoint), g.handleHealthz)
+	g.APISrv.GET(string(ReadyzEndpoint), g.handleReadyz)
+	g.APISrv.POST(string(SearchEndpoint), g.handle(SearchEndpoint, g.handleSearch))
+	g.APISrv.POST(string(QueryEndpoint), g.handle(QueryEndpoint, g.handleQuery))
+	g.APISrv.POST(string(AnnotationsEndpoint), g.handle(AnnotationsEndpoint, g.handleAnnotations))
+	g.APISrv.POST(string(TagKeysEndpoint), g.handle(TagKeysEndpoint, g.handleTagKeys))
+	g.APISrv.POST(string(TagValuesEndpoint), g.handle(TagValuesEndpoint, g.handleTagValues))
+	if g.store != nil {
+		g.APISrv.POST(string(WriteEndpoint), g.handle(WriteEndpoint, g.store.WriteHandle()))
+	}
 	g.APISrv.Configure()
-	g.APISrv.Logger = g.APISrv.Logger.Named(applicationName)
+	g.APISrv.Logger = g.APISrv.Logger.Named(applicationName).WithOptions(zap.WrapCore(g.levelCore))
+	if g.accessLog != nil {
+		g.accessLog.SetLogger(g.Logger(`access`))
+	}
+	if g.recorder != nil {
+		g.recorder.SetLogger(g.Logger(`recorder`))
+	}
 	for _, ep := range Endpoints {
 		if *ep != RootEndpoint {
 			g.APISrv.Logger.Info("Configured Endpoint", zap.String("Path", string(*ep)), zap.Bool("default backend handler", defaultHandler[string(*ep)]))
 		}
 	}
+	if g.store != nil {
+		g.APISrv.Logger.Info("Configured Write Endpoint", zap.String("Path", string(WriteEndpoint)), zap.Duration("retention", g.store.Retention))
+	}
+	g.APISrv.Logger.Info("Configured Health Endpoints", zap.String("liveness", string(HealthzEndpoint)), zap.String("readiness", string(ReadyzEndpoint)))
+	if g.limiter != nil {
+		if g.auth != nil {
+			g.limiter.SetAuthenticator(g.auth.Authenticate)
+		}
+		g.APISrv.Logger.Info("Configured Limiter", zap.Float64("client rate", g.limiter.PerClient.Rate), zap.Float64("identity rate", g.limiter.PerIdentity.Rate), zap.Float64("endpoint rate", g.limiter.PerEndpoint.Rate), zap.Int("max concurrent", g.limiter.MaxConcurrent()))
+	}
+	if g.incCache != nil {
+		g.incCache.SetLogger(g.Logger(`incremental-cache`))
+		g.APISrv.Logger.Info("Configured Incremental Cache", zap.Duration("tolerance", g.incCache.Tolerance), zap.Int("max entries", g.incCache.MaxEntries))
+	}
+	if g.coalescer != nil {
+		g.coalescer.SetLogger(g.Logger(`coalescer`))
+		g.APISrv.Logger.Info("Configured Request Coalescing")
+	}
+	if g.cache != nil {
+		g.cache.SetLogger(g.Logger(`cache`))
+		g.APISrv.Logger.Info("Configured Response Cache", zap.Duration("ttl", g.cache.TTL), zap.Int("max entries", g.cache.MaxEntries), zap.Int("max bytes", g.cache.MaxBytes))
+	}
+	for _, ep := range Endpoints {
+		if *ep != RootEndpoint {
+			if !defaultHandler[string(*ep)] {
+				g.beHandlers[*ep] = g.wrapBEHandler(*ep, g.beHandlers[*ep])
+			}
+		}
+	}
+}
+
+// wrapBEHandler applies panic recovery and the configured caching and coalescing to the BEHandler of the Endpoint.
+// Query results are indexed in the LabelIndex, converted to the Target Type using AutoFormat,
+// and Query Targets are resolved individually using ResolveTargets.
+// Panics are recovered around the whole chain, and below the layers calling the handler from other goroutines.
+func (g *GrafanaBackend) wrapBEHandler(ep Endpoint, handler BEHandler) BEHandler {
+	if ep == QueryEndpoint {
+		handler = g.labels.Wrap(handler)
+	}
+	if g.incCache != nil && ep == QueryEndpoint {
+		handler = g.incCache.Wrap(handler)
+	}
+	handler = g.recoverBEHandler(ep, handler)
+	if g.coalescer != nil {
+		handler = g.coalescer.Wrap(ep, handler)
+	}
+	if g.cache != nil {
+		handler = g.cache.Wrap(ep, handler)
+	}
+	if ep == QueryEndpoint {
+		handler = ResolveTargets(g.recoverBEHandler(ep, AutoFormat(handler)))
+	}
+	return g.recoverBEHandler(ep, handler)
+}
+
+// beHandler returns the current BEHandler of the Endpoint.
+func (g *GrafanaBackend) beHandler(ep Endpoint) BEHandler {
+	g.handlerLock.RLock()
+	defer g.handlerLock.RUnlock()
+	return g.beHandlers[ep]
 }
 
 func defaultHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -169,10 +365,10 @@ func (g *GrafanaBackend) Start() {
 	g.APISrv.Start()
 }
 
-// Stop stops the httpserver and storage modules.
+// Stop waits for in-flight requests to complete and stops the httpserver and storage modules.
+// Use Shutdown to bound the wait and handle errors.
 func (g *GrafanaBackend) Stop() {
-	err := g.APISrv.Stop()
-	if err != nil {
-		fmt.Println("Error:", err)
+	if err := g.Shutdown(context.Background()); err != nil {
+		g.APISrv.Logger.Error("stop failure", zap.Error(err))
 	}
 }
@@ -0,0 +1,240 @@
+package jsonds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// IncrementalCache caches TimeSeriesData per Target and only requests the window missing
+// from previous results when the Range of a QueryRequest moves forward.
+// Tolerance is re-requested before the end of the cached window to pick up late-arriving data.
+type IncrementalCache struct {
+	Tolerance  time.Duration
+	MaxEntries int
+
+	logger  *zap.Logger
+	entries map[string]*seriesEntry
+	lock    sync.Mutex
+}
+
+type seriesEntry struct {
+	rng         Range
+	data        []TimeSeriesData
+	lastUsed    time.Time
+	passthrough bool
+}
+
+// NewIncrementalCache returns a new IncrementalCache.
+func NewIncrementalCache(tolerance time.Duration, maxEntries int) *IncrementalCache {
+	return &IncrementalCache{
+		Tolerance:  tolerance,
+		MaxEntries: maxEntries,
+		logger:     zap.NewNop(),
+		entries:    make(map[string]*seriesEntry),
+	}
+}
+
+// SetLogger sets the logger used by the IncrementalCache.
+func (c *IncrementalCache) SetLogger(logger *zap.Logger) {
+	c.logger = logger
+}
+
+// Purge removes all cached series.
+func (c *IncrementalCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[string]*seriesEntry)
+}
+
+// Wrap returns a BEHandler which queries the given handler once per Target for the missing window only.
+// Requests other than QueryRequests, and QueryRequests containing table Targets, are passed through.
+// Targets for which the handler returned a non TimeSeries response are remembered and passed through as well.
+func (c *IncrementalCache) Wrap(handler BEHandler) BEHandler {
+	return func(req Request) (Response, error) {
+		if req.ReqType() != ReqQuery || c.passthrough(req.Query()) {
+			return handler(req)
+		}
+		q := req.Query()
+		var resp TimeSeriesResponse
+		for _, t := range q.Targets {
+			data, other, err := c.queryTarget(handler, req, t)
+			if err != nil {
+				return InvalidData{}, err
+			}
+			if other != nil {
+				if len(q.Targets) == 1 {
+					return other, nil
+				}
+				c.logger.Debug("non time series response, bypassing incremental cache")
+				return handler(req)
+			}
+			resp.Data = append(resp.Data, data...)
+		}
+		return resp, nil
+	}
+}
+
+// passthrough reports whether the QueryRequest contains table Targets
+// or Targets known to return non TimeSeries responses.
+func (c *IncrementalCache) passthrough(q *QueryRequest) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, t := range q.Targets {
+		if t.Type == TargetTable {
+			return true
+		}
+		key, err := targetKey(q, t)
+		if err != nil {
+			return true
+		}
+		if entry, ok := c.entries[key]; ok && entry.passthrough {
+			return true
+		}
+	}
+	return false
+}
+
+// queryTarget returns the TimeSeriesData of the Target over the Range of the QueryRequest,
+// or the Response of the handler for the whole Range if it is not a TimeSeriesResponse.
+func (c *IncrementalCache) queryTarget(handler BEHandler, req Request, t Target) ([]TimeSeriesData, Response, error) {
+	q := req.Query()
+	key, err := targetKey(q, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	fetch := q.Range
+	c.lock.Lock()
+	entry := c.entries[key]
+	c.lock.Unlock()
+	if entry != nil && !q.Range.From.Before(entry.rng.From) && !q.Range.From.After(entry.rng.To) && !q.Range.To.Before(entry.rng.To) {
+		fetch.From = entry.rng.To.Add(-c.Tolerance)
+		if fetch.From.Before(q.Range.From) {
+			fetch.From = q.Range.From
+		}
+		c.logger.Debug("incremental cache hit", zap.String("target", t.Target), zap.Time("from", fetch.From), zap.Time("to", fetch.To))
+	} else {
+		entry = nil
+		c.logger.Debug("incremental cache miss", zap.String("target", t.Target))
+	}
+	sub := *q
+	sub.Targets = []Target{t}
+	sub.Range = fetch
+	resp, err := handler(&sub)
+	if err != nil {
+		return nil, nil, err
+	}
+	ts, ok := resp.(TimeSeriesResponse)
+	if !ok {
+		c.store(key, &seriesEntry{passthrough: true, lastUsed: time.Now()})
+		if entry != nil {
+			sub.Range = q.Range
+			if resp, err = handler(&sub); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, resp, nil
+	}
+	data := ts.Data
+	if entry != nil {
+		data = stitchSeries(entry.data, data, toMS(fetch.From))
+	}
+	data = trimSeries(data, toMS(q.Range.From), toMS(q.Range.To))
+	c.store(key, &seriesEntry{
+		rng:      q.Range,
+		data:     data,
+		lastUsed: time.Now(),
+	})
+	return data, nil, nil
+}
+
+func (c *IncrementalCache) store(key string, entry *seriesEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[key] = entry
+	if c.MaxEntries <= 0 || len(c.entries) <= c.MaxEntries {
+		return
+	}
+	var oldest string
+	for k, e := range c.entries {
+		if oldest == `` || e.lastUsed.Before(c.entries[oldest].lastUsed) {
+			oldest = k
+		}
+	}
+	delete(c.entries, oldest)
+}
+
+// targetKey identifies a Target within a QueryRequest, ignoring the Range.
+func targetKey(q *QueryRequest, t Target) (string, error) {
+	b, err := json.Marshal(struct {
+		Target        Target        `json:"target"`
+		IntervalMS    int64         `json:"intervalMs"`
+		AdhocFilters  []AdhocFilter `json:"adhocFilters"`
+		MaxDataPoints int           `json:"maxDataPoints"`
+		ScopedVars    ScopedVar     `json:"scopedVars"`
+	}{
+		Target:        t,
+		IntervalMS:    q.InvervalMS,
+		AdhocFilters:  q.AdhocFilters,
+		MaxDataPoints: q.MaxDataPoints,
+		ScopedVars:    q.ScopedVars,
+	})
+	if err != nil {
+		return ``, err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// stitchSeries combines the cached Datapoints before the given timestamp with the fetched series.
+func stitchSeries(cached, fetched []TimeSeriesData, fromMS int64) []TimeSeriesData {
+	index := make(map[string]int, len(fetched))
+	for i, f := range fetched {
+		index[f.Target] = i
+	}
+	var stitched []TimeSeriesData
+	seen := make(map[string]bool, len(fetched))
+	for _, old := range cached {
+		series := TimeSeriesData{Target: old.Target, Labels: old.Labels}
+		for _, dp := range old.Datapoints {
+			if dp.UnixTimestampMS < fromMS {
+				series.Datapoints = append(series.Datapoints, dp)
+			}
+		}
+		if i, ok := index[old.Target]; ok {
+			series.Labels = fetched[i].Labels
+			series.Datapoints = append(series.Datapoints, fetched[i].Datapoints...)
+			seen[old.Target] = true
+		}
+		stitched = append(stitched, series)
+	}
+	for _, f := range fetched {
+		if !seen[f.Target] {
+			stitched = append(stitched, f)
+		}
+	}
+	return stitched
+}
+
+// trimSeries drops the Datapoints outside of the given range, inclusive.
+func trimSeries(data []TimeSeriesData, fromMS, toMS int64) []TimeSeriesData {
+	trimmed := make([]TimeSeriesData, 0, len(data))
+	for _, d := range data {
+		series := TimeSeriesData{Target: d.Target, Labels: d.Labels}
+		for _, dp := range d.Datapoints {
+			if dp.UnixTimestampMS >= fromMS && dp.UnixTimestampMS <= toMS {
+				series.Datapoints = append(series.Datapoints, dp)
+			}
+		}
+		trimmed = append(trimmed, series)
+	}
+	return trimmed
+}
+
+func toMS(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,68 @@
+package jsonds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIncrementalCache(t *testing.T) {
+	c := NewIncrementalCache(0, 0)
+	var fetched []Range
+	handler := c.Wrap(func(req Request) (Response, error) {
+		q := req.Query()
+		fetched = append(fetched, q.Range)
+		var series TimeSeriesData
+		series.Target = q.Targets[0].Target
+		for ts := q.Range.From; !ts.After(q.Range.To); ts = ts.Add(time.Minute) {
+			series.AddDataPoint(1, toMS(ts))
+		}
+		return TimeSeriesResponse{Data: []TimeSeriesData{series}}, nil
+	})
+	from := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	query := func(from time.Time) *QueryRequest {
+		return &QueryRequest{Range: Range{From: from, To: from.Add(10 * time.Minute)}, Targets: []Target{{Target: `a`}}}
+	}
+	if _, err := handler(query(from)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := handler(query(from.Add(2 * time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched) != 2 || !fetched[1].From.Equal(from.Add(10*time.Minute)) {
+		t.Fatalf("fetched ranges = %v, want the missing window only", fetched)
+	}
+	dps := resp.(TimeSeriesResponse).Data[0].Datapoints
+	if len(dps) != 11 || dps[0].UnixTimestampMS != toMS(from.Add(2*time.Minute)) || dps[10].UnixTimestampMS != toMS(from.Add(12*time.Minute)) {
+		t.Errorf("stitched datapoints = %v", dps)
+	}
+}
+
+func TestIncrementalCacheNonTimeSeries(t *testing.T) {
+	c := NewIncrementalCache(0, 0)
+	var calls int
+	handler := c.Wrap(func(req Request) (Response, error) {
+		calls++
+		return TableResponse{}, nil
+	})
+	q := &QueryRequest{Range: Range{From: time.Unix(0, 0), To: time.Unix(600, 0)}, Targets: []Target{{Target: `a`}, {Target: `b`}}}
+	for i, want := range []int{2, 3} {
+		resp, err := handler(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.RespType() != RespTable {
+			t.Errorf("response type = %v, want %v", resp.RespType(), RespTable)
+		}
+		if calls != want {
+			t.Errorf("request %d: handler calls = %d, want %d", i, calls, want)
+		}
+	}
+	single := &QueryRequest{Range: q.Range, Targets: []Target{{Target: `c`}}}
+	if _, err := handler(single); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 4 {
+		t.Errorf("single Target handler calls = %d, want 4", calls)
+	}
+}
@@ -0,0 +1,341 @@
+package jsonds
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// Target Data variables used for processing labelled TimeSeriesData:
+const (
+	LegendFormatVar = `legendFormat`
+	GroupByVar      = `groupBy`
+)
+
+var legendTemplate = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// Labels are the key value pairs identifying a TimeSeriesData.
+type Labels map[string]string
+
+// Get returns the value for the given label key.
+func (l Labels) Get(key string) string {
+	return l[key]
+}
+
+// Copy returns a copy of the labels, nil if the labels are nil.
+func (l Labels) Copy() Labels {
+	if l == nil {
+		return nil
+	}
+	copied := make(Labels, len(l))
+	for k, v := range l {
+		copied[k] = v
+	}
+	return copied
+}
+
+// Keys returns the sorted label keys.
+func (l Labels) Keys() []string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// String returns the labels in the form {key="value", ...}.
+func (l Labels) String() string {
+	pairs := make([]string, 0, len(l))
+	for _, k := range l.Keys() {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, l[k]))
+	}
+	return `{` + strings.Join(pairs, `, `) + `}`
+}
+
+// Format replaces all {{key}} occurrences in the given format with their label values.
+// Unknown keys are replaced with an empty string.
+func (l Labels) Format(format string) string {
+	return legendTemplate.ReplaceAllStringFunc(format, func(m string) string {
+		return l[legendTemplate.FindStringSubmatch(m)[1]]
+	})
+}
+
+// Match returns true if the labels satisfy all the given AdhocFilters.
+// Supported operators are =, !=, <, >, =~ and !~.
+func (l Labels) Match(filters ...AdhocFilter) bool {
+	return l.match(compileFilters(filters))
+}
+
+func (l Labels) match(filters []filterMatcher) bool {
+	for _, f := range filters {
+		if !f.match(l[f.Key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match returns true if the given value satisfies the AdhocFilter.
+func (f AdhocFilter) Match(value string) bool {
+	return compileFilter(f).match(value)
+}
+
+// filterMatcher is an AdhocFilter with its regex compiled once for matching many values.
+type filterMatcher struct {
+	AdhocFilter
+	re *regexp.Regexp
+}
+
+// compileFilter compiles the regex of =~ and !~ AdhocFilters. Invalid regexes match no value.
+func compileFilter(f AdhocFilter) filterMatcher {
+	m := filterMatcher{AdhocFilter: f}
+	if f.Operator == `=~` || f.Operator == `!~` {
+		m.re, _ = regexp.Compile(`^(?:` + f.Value + `)$`)
+	}
+	return m
+}
+
+func compileFilters(filters []AdhocFilter) []filterMatcher {
+	matchers := make([]filterMatcher, len(filters))
+	for i, f := range filters {
+		matchers[i] = compileFilter(f)
+	}
+	return matchers
+}
+
+func (f filterMatcher) match(value string) bool {
+	switch f.Operator {
+	case `=`, ``:
+		return value == f.Value
+	case `!=`:
+		return value != f.Value
+	case `<`, `>`:
+		a, errA := cast.ToFloat64E(value)
+		b, errB := cast.ToFloat64E(f.Value)
+		if errA != nil || errB != nil {
+			if f.Operator == `<` {
+				return value < f.Value
+			}
+			return value > f.Value
+		}
+		if f.Operator == `<` {
+			return a < b
+		}
+		return a > b
+	case `=~`, `!~`:
+		if f.re == nil {
+			return false
+		}
+		return f.re.MatchString(value) == (f.Operator == `=~`)
+	}
+	return false
+}
+
+// SetLabel sets a label on the TimeSeriesData.
+func (t *TimeSeriesData) SetLabel(key, value string) {
+	if t.Labels == nil {
+		t.Labels = make(Labels)
+	}
+	t.Labels[key] = value
+}
+
+// ApplyLegend sets the Target name using the given format and the labels of the TimeSeriesData.
+// An empty format leaves the Target unchanged.
+func (t *TimeSeriesData) ApplyLegend(format string) {
+	if format != `` {
+		t.Target = t.Labels.Format(format)
+	}
+}
+
+// LegendFormat returns the legend format set in the Target Data, if any.
+func (t *Target) LegendFormat() string {
+	return cast.ToString(t.Data[LegendFormatVar])
+}
+
+// FilterSeries returns the TimeSeriesData whose labels satisfy all the given AdhocFilters.
+func FilterSeries(data []TimeSeriesData, filters ...AdhocFilter) []TimeSeriesData {
+	if len(filters) == 0 {
+		return data
+	}
+	matchers := compileFilters(filters)
+	var filtered []TimeSeriesData
+	for _, d := range data {
+		if d.Labels.match(matchers) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
+
+// GroupSeries groups the TimeSeriesData by the given label keys, summing Datapoints sharing a timestamp.
+// Each resulting series carries only the grouped labels and is named after them.
+func GroupSeries(data []TimeSeriesData, keys ...string) []TimeSeriesData {
+	var groups []TimeSeriesData
+	index := make(map[string]int)
+	sums := make(map[string]map[int64]float64)
+	for _, d := range data {
+		labels := make(Labels, len(keys))
+		for _, k := range keys {
+			labels[k] = d.Labels[k]
+		}
+		id := labels.String()
+		if _, ok := index[id]; !ok {
+			index[id] = len(groups)
+			groups = append(groups, TimeSeriesData{Target: id, Labels: labels})
+			sums[id] = make(map[int64]float64)
+		}
+		for _, dp := range d.Datapoints {
+			sums[id][dp.UnixTimestampMS] += dp.MetricValue
+		}
+	}
+	for i := range groups {
+		points := sums[groups[i].Target]
+		timestamps := make([]int64, 0, len(points))
+		for ts := range points {
+			timestamps = append(timestamps, ts)
+		}
+		sort.Slice(timestamps, func(a, b int) bool { return timestamps[a] < timestamps[b] })
+		for _, ts := range timestamps {
+			groups[i].AddDataPoint(points[ts], ts)
+		}
+	}
+	return groups
+}
+
+// defaultLabelTTL is the default TTL of a LabelIndex.
+const defaultLabelTTL = 24 * time.Hour
+
+// LabelIndex collects the labels of TimeSeriesData for answering TagKeys and TagValues requests.
+// Label values not seen in the TTL are pruned, so series which stop appearing drop out of the index.
+// A zero TTL keeps all label values.
+type LabelIndex struct {
+	TTL time.Duration
+
+	values map[string]map[string]time.Time
+	pruned time.Time
+	lock   sync.RWMutex
+}
+
+// NewLabelIndex returns a new LabelIndex with a TTL of 24 hours.
+func NewLabelIndex() *LabelIndex {
+	return &LabelIndex{
+		TTL:    defaultLabelTTL,
+		values: make(map[string]map[string]time.Time),
+	}
+}
+
+// Add indexes the labels of the given TimeSeriesData, pruning expired label values at most once per TTL.
+func (x *LabelIndex) Add(data ...TimeSeriesData) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	now := time.Now()
+	for _, d := range data {
+		for k, v := range d.Labels {
+			if x.values[k] == nil {
+				x.values[k] = make(map[string]time.Time)
+			}
+			x.values[k][v] = now
+		}
+	}
+	if x.TTL > 0 && now.Sub(x.pruned) >= x.TTL {
+		x.prune(now)
+	}
+}
+
+// prune removes the label values not seen in the TTL, and the keys left without values.
+func (x *LabelIndex) prune(now time.Time) {
+	for k, values := range x.values {
+		for v, seen := range values {
+			if now.Sub(seen) > x.TTL {
+				delete(values, v)
+			}
+		}
+		if len(values) == 0 {
+			delete(x.values, k)
+		}
+	}
+	x.pruned = now
+}
+
+// live returns true if the label value seen at the given time has not expired.
+func (x *LabelIndex) live(seen, now time.Time) bool {
+	return x.TTL <= 0 || now.Sub(seen) <= x.TTL
+}
+
+// TagKeys returns all indexed label keys as string TagKeys.
+func (x *LabelIndex) TagKeys() TagKeysResp {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	now := time.Now()
+	resp := TagKeysResp{Data: make([]TagKey, 0, len(x.values))}
+	for k, values := range x.values {
+		for _, seen := range values {
+			if x.live(seen, now) {
+				resp.Data = append(resp.Data, TagKey{Type: string(KeyTypeString), Text: k})
+				break
+			}
+		}
+	}
+	sort.Slice(resp.Data, func(i, j int) bool { return resp.Data[i].Text < resp.Data[j].Text })
+	return resp
+}
+
+// TagValues returns all indexed values for the given label key.
+func (x *LabelIndex) TagValues(key string) TagValuesResp {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	now := time.Now()
+	resp := TagValuesResp{Data: make([]TagValue, 0, len(x.values[key]))}
+	for v, seen := range x.values[key] {
+		if x.live(seen, now) {
+			resp.Data = append(resp.Data, TagValue{Text: v})
+		}
+	}
+	sort.Slice(resp.Data, func(i, j int) bool { return resp.Data[i].Text < resp.Data[j].Text })
+	return resp
+}
+
+// Wrap returns a BEHandler indexing the labels of the TimeSeriesData returned by the handler for Query Requests.
+func (x *LabelIndex) Wrap(handler BEHandler) BEHandler {
+	return func(req Request) (Response, error) {
+		resp, err := handler(req)
+		if ts, ok := resp.(TimeSeriesResponse); ok && err == nil {
+			x.Add(ts.Data...)
+		}
+		return resp, err
+	}
+}
+
+// BEHandler returns a BEHandler answering TagKeys and TagValues requests from the LabelIndex.
+func (x *LabelIndex) BEHandler() BEHandler {
+	return func(req Request) (Response, error) {
+		switch req.ReqType() {
+		case ReqTagKeys:
+			return x.TagKeys(), nil
+		case ReqTagValue:
+			return x.TagValues(req.TagValues().Key), nil
+		}
+		return defaultBEHandler(req)
+	}
+}
+
+// Process applies the legend format of the given Target and the AdhocFilters of the QueryRequest
+// to the TimeSeriesData, grouping by the Target GroupByVar variable when set.
+func (r *QueryRequest) Process(t *Target, data []TimeSeriesData) []TimeSeriesData {
+	data = FilterSeries(data, r.AdhocFilters...)
+	if keys := t.GetVarStrings(GroupByVar); t.Data[GroupByVar] != nil && len(keys) > 0 {
+		data = GroupSeries(data, keys...)
+	}
+	if format := t.LegendFormat(); format != `` {
+		for i := range data {
+			data[i].ApplyLegend(format)
+		}
+	}
+	return data
+}
@@ -0,0 +1,87 @@
+package jsonds
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAdhocFilterMatch(t *testing.T) {
+	tests := []struct {
+		op, filter, value string
+		want              bool
+	}{
+		{`=`, `a`, `a`, true},
+		{`=`, `a`, `b`, false},
+		{`!=`, `a`, `b`, true},
+		{`<`, `10`, `9`, true},
+		{`>`, `10`, `9`, false},
+		{`=~`, `^web-\d+$`, `web-12`, true},
+		{`!~`, `^web`, `db-1`, true},
+		{`=~`, `(`, `(`, false},
+		{`!~`, `(`, `(`, false},
+	}
+	for _, tt := range tests {
+		f := AdhocFilter{Key: `k`, Operator: tt.op, Value: tt.filter}
+		if got := f.Match(tt.value); got != tt.want {
+			t.Errorf("%q %v %q = %v, want %v", tt.value, tt.op, tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	data := []TimeSeriesData{
+		{Target: `a`, Labels: Labels{`host`: `h1`, `dc`: `x`}, Datapoints: []Datapoint{{1, 1000}, {2, 2000}}},
+		{Target: `b`, Labels: Labels{`host`: `h2`, `dc`: `x`}, Datapoints: []Datapoint{{3, 1000}}},
+		{Target: `c`, Labels: Labels{`host`: `h3`, `dc`: `y`}, Datapoints: []Datapoint{{5, 1000}}},
+	}
+	q := &QueryRequest{AdhocFilters: []AdhocFilter{{Key: `host`, Operator: `!=`, Value: `h3`}}}
+	target := &Target{Data: map[string]interface{}{GroupByVar: `dc`, LegendFormatVar: `dc {{dc}}`}}
+	got := q.Process(target, data)
+	want := []TimeSeriesData{
+		{Target: `dc x`, Labels: Labels{`dc`: `x`}, Datapoints: []Datapoint{{4, 1000}, {2, 2000}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Process = %+v, want %+v", got, want)
+	}
+}
+
+func TestLabelIndexWrap(t *testing.T) {
+	x := NewLabelIndex()
+	handler := x.Wrap(func(Request) (Response, error) {
+		return TimeSeriesResponse{Data: []TimeSeriesData{
+			{Target: `a`, Labels: Labels{`host`: `h2`, `topic`: `t1`}},
+			{Target: `b`, Labels: Labels{`host`: `h1`}},
+		}}, nil
+	})
+	if _, err := handler(&QueryRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	keys := x.TagKeys()
+	if len(keys.Data) != 2 || keys.Data[0].Text != `host` || keys.Data[1].Text != `topic` {
+		t.Errorf("TagKeys = %+v", keys.Data)
+	}
+	values := x.TagValues(`host`)
+	if len(values.Data) != 2 || values.Data[0].Text != `h1` || values.Data[1].Text != `h2` {
+		t.Errorf("TagValues = %+v", values.Data)
+	}
+}
+
+func TestLabelIndexTTL(t *testing.T) {
+	x := NewLabelIndex()
+	x.TTL = 20 * time.Millisecond
+	x.Add(TimeSeriesData{Labels: Labels{`host`: `h1`, `dc`: `x`}})
+	time.Sleep(30 * time.Millisecond)
+	if values := x.TagValues(`host`); len(values.Data) != 0 {
+		t.Errorf("TagValues = %+v, want the expired values hidden", values.Data)
+	}
+	x.Add(TimeSeriesData{Labels: Labels{`host`: `h2`}})
+	if keys := x.TagKeys(); len(keys.Data) != 1 || keys.Data[0].Text != `host` {
+		t.Errorf("TagKeys = %+v, want host only", keys.Data)
+	}
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	if len(x.values) != 1 || len(x.values[`host`]) != 1 {
+		t.Errorf("index = %v, want the expired values pruned", x.values)
+	}
+}
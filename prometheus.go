@@ -0,0 +1,291 @@
+package jsonds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// promMaxPoints is the maximum number of points per series Prometheus returns for a query_range call.
+const promMaxPoints = 11000
+
+// PrometheusBackend answers Requests by calling the HTTP API of a Prometheus compatible server.
+//
+// Query Targets are PromQL expressions, taken from the Target "expr" variable when set, else the target itself,
+// and are run using query_range. The following variables are replaced before the call:
+//
+//	$__interval       the step, e.g. 30s
+//	$__interval_ms    the step in milliseconds, e.g. 30000
+//	$__range          the duration of the Range, e.g. 3600s
+//
+// Matrix results become labelled TimeSeriesData named after their metric and labels, and are passed through
+// QueryRequest.Process. Search lists the values of the SearchLabel containing the SearchRequest target,
+// and TagKeys and TagValues list the label names and values.
+type PrometheusBackend struct {
+	URL    string
+	Client *http.Client
+
+	// Header is added to every request, e.g. for authorization.
+	Header http.Header
+
+	// MinStep is the minimum step of query_range calls. Defaults to 1s.
+	MinStep time.Duration
+
+	// SearchLabel is the label whose values are listed by Search. Defaults to __name__.
+	SearchLabel string
+
+	// MaxResponseBytes limits the size of API responses. Defaults to 64MiB, zero disables the limit.
+	MaxResponseBytes int64
+}
+
+// NewPrometheusBackend returns a new PrometheusBackend for the given base URL, e.g. http://localhost:9090.
+func NewPrometheusBackend(baseURL string) *PrometheusBackend {
+	return &PrometheusBackend{
+		URL:              strings.TrimRight(baseURL, `/`),
+		Client:           http.DefaultClient,
+		MinStep:          time.Second,
+		SearchLabel:      `__name__`,
+		MaxResponseBytes: defaultMaxResponseBytes,
+	}
+}
+
+// promResponse is the envelope of all Prometheus API responses.
+type promResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// promMatrix is the data of a query_range response.
+type promMatrix struct {
+	ResultType string `json:"resultType"`
+	Result     []struct {
+		Metric Labels           `json:"metric"`
+		Values [][2]interface{} `json:"values"`
+	} `json:"result"`
+}
+
+// BEHandler returns a BEHandler answering Search, Query, TagKeys and TagValues Requests.
+func (b *PrometheusBackend) BEHandler() BEHandler {
+	return func(req Request) (Response, error) {
+		switch req.ReqType() {
+		case ReqSearch:
+			values, err := b.labelValues(RequestContext(req), b.SearchLabel)
+			if err != nil {
+				return InvalidData{}, err
+			}
+			var resp SearchResponse
+			for _, v := range values {
+				if strings.Contains(v, req.Search().Target) {
+					resp.Data = append(resp.Data, v)
+				}
+			}
+			return resp, nil
+		case ReqQuery:
+			return b.query(req)
+		case ReqTagKeys:
+			var names []string
+			if err := b.get(RequestContext(req), `/api/v1/labels`, nil, &names); err != nil {
+				return InvalidData{}, err
+			}
+			var resp TagKeysResp
+			for _, n := range names {
+				if n != `__name__` {
+					resp.Data = append(resp.Data, TagKey{Type: `string`, Text: n})
+				}
+			}
+			return resp, nil
+		case ReqTagValue:
+			values, err := b.labelValues(RequestContext(req), req.TagValues().Key)
+			if err != nil {
+				return InvalidData{}, err
+			}
+			var resp TagValuesResp
+			for _, v := range values {
+				resp.Data = append(resp.Data, TagValue{Text: v})
+			}
+			return resp, nil
+		}
+		return defaultBEHandler(req)
+	}
+}
+
+func (b *PrometheusBackend) labelValues(ctx context.Context, label string) ([]string, error) {
+	var values []string
+	err := b.get(ctx, `/api/v1/label/`+url.PathEscape(label)+`/values`, nil, &values)
+	return values, err
+}
+
+func (b *PrometheusBackend) query(req Request) (Response, error) {
+	q := req.Query()
+	step := b.Step(q)
+	var series TimeSeriesResponse
+	var tables TableResponse
+	for _, t := range q.Targets {
+		expr := cast.ToString(t.GetVar(`expr`))
+		if expr == `` {
+			expr = t.Target
+		}
+		// $__interval_ms comes first as the replacer tries the pairs in order.
+		expr = strings.NewReplacer(
+			`$__interval_ms`, strconv.FormatInt(step.Milliseconds(), 10),
+			`$__interval`, promDuration(step),
+			`$__range`, promDuration(q.Range.To.Sub(q.Range.From)),
+		).Replace(expr)
+		data, err := b.QueryRange(RequestContext(req), expr, q.Range.From, q.Range.To, step)
+		if err != nil {
+			return InvalidData{}, fmt.Errorf("prometheus backend: target %q: %v", t.Target, err)
+		}
+		data = q.Process(&t, data)
+		if t.Type == TargetTable {
+			tables.Data = append(tables.Data, SeriesToLongTable(data))
+			continue
+		}
+		series.Data = append(series.Data, data...)
+	}
+	if len(tables.Data) > 0 {
+		if len(series.Data) > 0 {
+			tables.Data = append(tables.Data, SeriesToWideTable(series.Data))
+		}
+		return tables, nil
+	}
+	return series, nil
+}
+
+// Step returns the query_range step for the QueryRequest: its interval, raised to MinStep and to
+// keep the number of points within the Prometheus limit, and rounded up to the millisecond.
+func (b *PrometheusBackend) Step(q *QueryRequest) time.Duration {
+	step := time.Duration(q.InvervalMS) * time.Millisecond
+	if step < b.MinStep {
+		step = b.MinStep
+	}
+	if min := q.Range.To.Sub(q.Range.From) / promMaxPoints; step < min {
+		step = min
+	}
+	if rounded := step.Truncate(time.Millisecond); rounded < step {
+		return rounded + time.Millisecond
+	}
+	return step
+}
+
+// QueryRange runs the PromQL expression over the given range and returns the labelled series.
+func (b *PrometheusBackend) QueryRange(ctx context.Context, expr string, from, to time.Time, step time.Duration) ([]TimeSeriesData, error) {
+	params := url.Values{
+		`query`: {expr},
+		`start`: {promTime(from)},
+		`end`:   {promTime(to)},
+		`step`:  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	}
+	var m promMatrix
+	if err := b.get(ctx, `/api/v1/query_range`, params, &m); err != nil {
+		return nil, err
+	}
+	if m.ResultType != `matrix` {
+		return nil, fmt.Errorf("unexpected result type %q", m.ResultType)
+	}
+	data := make([]TimeSeriesData, 0, len(m.Result))
+	for _, r := range m.Result {
+		ts := TimeSeriesData{
+			Target: promSeriesName(r.Metric),
+			Labels: r.Metric,
+		}
+		for _, v := range r.Values {
+			sec, ok := v[0].(float64)
+			if !ok {
+				return nil, fmt.Errorf("invalid sample timestamp %v", v[0])
+			}
+			val, err := strconv.ParseFloat(cast.ToString(v[1]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sample value %v", v[1])
+			}
+			if math.IsNaN(val) || math.IsInf(val, 0) {
+				continue
+			}
+			ts.AddDataPoint(val, int64(math.Round(sec*1000)))
+		}
+		data = append(data, ts)
+	}
+	return data, nil
+}
+
+// get calls the API path and decodes the data of the response into out.
+func (b *PrometheusBackend) get(ctx context.Context, path string, params url.Values, out interface{}) error {
+	u := b.URL + path
+	if len(params) > 0 {
+		u += `?` + params.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range b.Header {
+		req.Header[k] = v
+	}
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	r := io.Reader(resp.Body)
+	if b.MaxResponseBytes > 0 {
+		r = io.LimitReader(resp.Body, b.MaxResponseBytes+1)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if b.MaxResponseBytes > 0 && int64(len(body)) > b.MaxResponseBytes {
+		return fmt.Errorf("%v: response exceeds %d bytes", resp.Status, b.MaxResponseBytes)
+	}
+	var pr promResponse
+	if err := json.Unmarshal(body, &pr); err != nil {
+		return fmt.Errorf("%v: invalid response: %v", resp.Status, err)
+	}
+	if pr.Status != `success` {
+		return fmt.Errorf("%v: %v: %v", resp.Status, pr.ErrorType, pr.Error)
+	}
+	return json.Unmarshal(pr.Data, out)
+}
+
+// promSeriesName returns the name of a series in PromQL notation, e.g. up{job="node"}.
+func promSeriesName(metric Labels) string {
+	name := metric[`__name__`]
+	keys := make([]string, 0, len(metric))
+	for k := range metric {
+		if k != `__name__` {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 && name != `` {
+		return name
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + `=` + strconv.Quote(metric[k])
+	}
+	return name + `{` + strings.Join(pairs, `, `) + `}`
+}
+
+func promTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64)
+}
+
+func promDuration(d time.Duration) string {
+	if ms := d.Milliseconds(); ms%1000 != 0 {
+		return strconv.FormatInt(ms, 10) + `ms`
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10) + `s`
+}
@@ -0,0 +1,123 @@
+package jsonds
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusStep(t *testing.T) {
+	from := time.Unix(0, 0)
+	b := NewPrometheusBackend(`http://localhost:9090`)
+	tests := []struct {
+		name       string
+		rng        time.Duration
+		intervalMS int64
+		want       time.Duration
+	}{
+		{`interval`, time.Hour, 30000, 30 * time.Second},
+		{`min step`, time.Hour, 100, time.Second},
+		{`max points`, 110000 * time.Second, 1000, 10 * time.Second},
+		{`max points rounded up`, 24 * time.Hour, 1000, 7855 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		q := &QueryRequest{Range: Range{From: from, To: from.Add(tt.rng)}, InvervalMS: tt.intervalMS}
+		step := b.Step(q)
+		if step != tt.want {
+			t.Errorf("%v: Step = %v, want %v", tt.name, step, tt.want)
+		}
+		if points := tt.rng / step; points > promMaxPoints {
+			t.Errorf("%v: %d points, over the limit", tt.name, points)
+		}
+	}
+}
+
+func TestPrometheusBackend(t *testing.T) {
+	var queries []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case `/api/v1/query_range`:
+			queries = append(queries, r.URL.Query())
+			w.Write([]byte(`{"status": "success", "data": {"resultType": "matrix", "result": [
+				{"metric": {"__name__": "up", "job": "node"}, "values": [[60, "1"], [120, "NaN"], [180, "0"]]}
+			]}}`))
+		case `/api/v1/label/__name__/values`:
+			w.Write([]byte(`{"status": "success", "data": ["up", "node_load1"]}`))
+		case `/api/v1/labels`:
+			w.Write([]byte(`{"status": "success", "data": ["__name__", "job"]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status": "error", "errorType": "bad_data", "error": "unknown path"}`))
+		}
+	}))
+	defer srv.Close()
+	handler := NewPrometheusBackend(srv.URL).BEHandler()
+
+	q := &QueryRequest{
+		Range:      Range{From: time.Unix(0, 0), To: time.Unix(3600, 0)},
+		InvervalMS: 30000,
+		Targets:    []Target{{Target: `rate(up[$__interval]) * $__interval_ms / $__range`}},
+	}
+	resp, err := handler(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TimeSeriesResponse{Data: []TimeSeriesData{{
+		Target:     `up{job="node"}`,
+		Labels:     Labels{`__name__`: `up`, `job`: `node`},
+		Datapoints: []Datapoint{{1, 60000}, {0, 180000}},
+	}}}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("query response = %+v, want %+v", resp, want)
+	}
+	if len(queries) != 1 {
+		t.Fatalf("%d query_range calls, want 1", len(queries))
+	}
+	wantParams := url.Values{
+		`query`: {`rate(up[30s]) * 30000 / 3600s`},
+		`start`: {`0.000`},
+		`end`:   {`3600.000`},
+		`step`:  {`30`},
+	}
+	if !reflect.DeepEqual(queries[0], wantParams) {
+		t.Errorf("query_range params = %v, want %v", queries[0], wantParams)
+	}
+
+	resp, err = handler(&SearchRequest{Target: `load`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SearchResponse{Data: []string{`node_load1`}}); !reflect.DeepEqual(resp, want) {
+		t.Errorf("search response = %+v, want %+v", resp, want)
+	}
+	resp, err = handler(&TagKeysReq{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (TagKeysResp{Data: []TagKey{{Type: `string`, Text: `job`}}}); !reflect.DeepEqual(resp, want) {
+		t.Errorf("tag keys response = %+v, want %+v", resp, want)
+	}
+	if _, err := handler(&TagValuesReq{Key: `job`}); err == nil {
+		t.Error("tag values of an unknown path succeeded, want the API error")
+	}
+}
+
+func TestPrometheusMaxResponseBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "data": ["up", "node_load1"]}`))
+	}))
+	defer srv.Close()
+	b := NewPrometheusBackend(srv.URL)
+	b.MaxResponseBytes = 20
+	if _, err := b.BEHandler()(&SearchRequest{}); err == nil || !strings.Contains(err.Error(), `exceeds 20 bytes`) {
+		t.Fatalf("error = %v, want the response limit exceeded", err)
+	}
+	b.MaxResponseBytes = 0
+	if _, err := b.BEHandler()(&SearchRequest{}); err != nil {
+		t.Fatal(err)
+	}
+}
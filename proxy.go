@@ -0,0 +1,339 @@
+package jsonds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cast"
+)
+
+// Upstream is a JSON datasource the ProxyBackend forwards requests to.
+// Targets beginning with Prefix are routed to the Upstream with the Prefix removed, and target names
+// returned by the Upstream, including the number columns of tables, are prefixed.
+// An Upstream with an empty Prefix receives all unmatched targets.
+type Upstream struct {
+	Name   string
+	URL    string
+	Prefix string
+
+	// Header is added to every request sent to the Upstream, e.g. for authorization.
+	Header http.Header
+
+	// Rename, if set, rewrites the target names returned by the Upstream instead of prefixing them with Prefix.
+	Rename func(target string) string
+
+	// Paths overrides the Endpoint paths of the Upstream by RequestType. Defaults to the standard paths.
+	Paths map[RequestType]string
+}
+
+// upstreamPaths are the default Endpoint paths of an Upstream.
+var upstreamPaths = map[RequestType]string{
+	ReqSearch:     `/search`,
+	ReqQuery:      `/query`,
+	ReqAnnotation: `/annotations`,
+	ReqTagKeys:    `/tag-keys`,
+	ReqTagValue:   `/tag-values`,
+}
+
+// name returns the target name as exposed by the ProxyBackend.
+func (u *Upstream) name(target string) string {
+	if u.Rename != nil {
+		return u.Rename(target)
+	}
+	return u.Prefix + target
+}
+
+func (u *Upstream) path(rt RequestType) string {
+	if p, ok := u.Paths[rt]; ok {
+		return p
+	}
+	return upstreamPaths[rt]
+}
+
+// ProxyBackend forwards requests to multiple Upstream JSON datasources and merges their responses.
+// Search, TagKeys and TagValues requests fan out to all Upstreams, Query requests to the Upstreams
+// owning the targets, and Annotation requests to the Upstream owning the annotation query, or all of them.
+// When some Upstreams fail, the merged responses of the others are returned along with the errors.
+type ProxyBackend struct {
+	Client    *http.Client
+	Upstreams []*Upstream
+
+	// MaxResponseBytes limits the size of Upstream responses. A zero MaxResponseBytes disables the limit.
+	MaxResponseBytes int64
+}
+
+// defaultMaxResponseBytes is the default MaxResponseBytes of a ProxyBackend.
+const defaultMaxResponseBytes = 64 << 20
+
+// NewProxyBackend returns a new ProxyBackend using http.DefaultClient.
+// Upstreams with longer Prefixes take precedence.
+func NewProxyBackend(upstreams ...*Upstream) *ProxyBackend {
+	sorted := append([]*Upstream(nil), upstreams...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Prefix) > len(sorted[j].Prefix) })
+	return &ProxyBackend{
+		Client:           http.DefaultClient,
+		Upstreams:        sorted,
+		MaxResponseBytes: defaultMaxResponseBytes,
+	}
+}
+
+// route returns the Upstream owning the target.
+func (p *ProxyBackend) route(target string) (*Upstream, bool) {
+	for _, u := range p.Upstreams {
+		if strings.HasPrefix(target, u.Prefix) {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// BEHandler returns a BEHandler forwarding all Requests to the Upstreams.
+func (p *ProxyBackend) BEHandler() BEHandler {
+	return func(req Request) (Response, error) {
+		switch req.ReqType() {
+		case ReqSearch:
+			return p.search(req)
+		case ReqQuery:
+			return p.query(req)
+		case ReqAnnotation:
+			return p.annotations(req)
+		case ReqTagKeys:
+			return p.tagKeys(req)
+		case ReqTagValue:
+			return p.tagValues(req)
+		}
+		return defaultBEHandler(req)
+	}
+}
+
+// fanOut calls fn for each Upstream concurrently, returning the number of failed Upstreams and their errors.
+func (p *ProxyBackend) fanOut(upstreams []*Upstream, fn func(i int, u *Upstream) error) (int, error) {
+	errs := make([]error, len(upstreams))
+	var wg sync.WaitGroup
+	for i, u := range upstreams {
+		wg.Add(1)
+		go func(i int, u *Upstream) {
+			defer wg.Done()
+			errs[i] = fn(i, u)
+		}(i, u)
+	}
+	wg.Wait()
+	var failed int
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	return failed, errors.Join(errs...)
+}
+
+// post sends the body to the Endpoint of the Upstream and decodes the JSON response into out.
+func (p *ProxyBackend) post(ctx context.Context, u *Upstream, rt RequestType, body, out interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(u.URL, `/`)+u.path(rt), bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("upstream %v: %v", u.Name, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(`Content-Type`, `application/json`)
+	for k, v := range u.Header {
+		req.Header[k] = v
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("upstream %v: %v", u.Name, err)
+	}
+	defer resp.Body.Close()
+	r := io.Reader(resp.Body)
+	if p.MaxResponseBytes > 0 {
+		r = io.LimitReader(resp.Body, p.MaxResponseBytes+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("upstream %v: %v", u.Name, err)
+	}
+	if p.MaxResponseBytes > 0 && int64(len(data)) > p.MaxResponseBytes {
+		return fmt.Errorf("upstream %v: response exceeds %d bytes", u.Name, p.MaxResponseBytes)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		if json.Unmarshal(data, &e) == nil && e.Message != `` {
+			return fmt.Errorf("upstream %v: %v: %v", u.Name, resp.Status, e.Message)
+		}
+		return fmt.Errorf("upstream %v: %v", u.Name, resp.Status)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("upstream %v: invalid response: %v", u.Name, err)
+	}
+	return nil
+}
+
+func (p *ProxyBackend) search(req Request) (Response, error) {
+	target := req.Search().Target
+	results := make([]SearchResponse, len(p.Upstreams))
+	failed, err := p.fanOut(p.Upstreams, func(i int, u *Upstream) error {
+		sr := SearchRequest{Target: target, object: req.Search().object}
+		if !sr.object && u.Prefix != `` && strings.HasPrefix(target, u.Prefix) {
+			sr.Target = strings.TrimPrefix(target, u.Prefix)
+		}
+		return p.post(RequestContext(req), u, ReqSearch, &sr, &results[i])
+	})
+	if err != nil && failed == len(p.Upstreams) {
+		return InvalidData{}, err
+	}
+	var resp SearchResponse
+	for i, u := range p.Upstreams {
+		for _, d := range results[i].Data {
+			resp.Data = append(resp.Data, u.name(d))
+		}
+		for _, v := range results[i].Values {
+			if u.Prefix != `` || u.Rename != nil {
+				v.Value = u.name(cast.ToString(v.Value))
+			}
+			resp.Values = append(resp.Values, v)
+		}
+	}
+	return resp, err
+}
+
+func (p *ProxyBackend) query(req Request) (Response, error) {
+	q := req.Query()
+	var upstreams []*Upstream
+	subs := make(map[*Upstream]*QueryRequest)
+	for _, t := range q.Targets {
+		u, ok := p.route(t.Target)
+		if !ok {
+			return InvalidData{}, fmt.Errorf("proxy backend: no upstream for target %q", t.Target)
+		}
+		sub, ok := subs[u]
+		if !ok {
+			copied := *q
+			copied.Targets = nil
+			sub = &copied
+			subs[u] = sub
+			upstreams = append(upstreams, u)
+		}
+		t.Target = strings.TrimPrefix(t.Target, u.Prefix)
+		sub.Targets = append(sub.Targets, t)
+	}
+	results := make([][]json.RawMessage, len(upstreams))
+	failed, err := p.fanOut(upstreams, func(i int, u *Upstream) error {
+		return p.post(RequestContext(req), u, ReqQuery, subs[u], &results[i])
+	})
+	if err != nil && failed == len(upstreams) {
+		return InvalidData{}, err
+	}
+	var series TimeSeriesResponse
+	var tables TableResponse
+	for i, u := range upstreams {
+		for _, raw := range results[i] {
+			var probe map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &probe); err != nil {
+				return InvalidData{}, fmt.Errorf("upstream %v: invalid response: %v", u.Name, err)
+			}
+			if probe[`columns`] != nil {
+				var td TableData
+				if err := json.Unmarshal(raw, &td); err != nil {
+					return InvalidData{}, fmt.Errorf("upstream %v: invalid table: %v", u.Name, err)
+				}
+				for j, c := range td.Columns {
+					if KeyType(c.Type) == KeyTypeNumber {
+						td.Columns[j].Text = u.name(c.Text)
+					}
+				}
+				tables.Data = append(tables.Data, td)
+				continue
+			}
+			var ts TimeSeriesData
+			if err := json.Unmarshal(raw, &ts); err != nil {
+				return InvalidData{}, fmt.Errorf("upstream %v: invalid series: %v", u.Name, err)
+			}
+			ts.Target = u.name(ts.Target)
+			series.Data = append(series.Data, ts)
+		}
+	}
+	if len(tables.Data) > 0 {
+		if len(series.Data) > 0 {
+			tables.Data = append(tables.Data, SeriesToWideTable(series.Data))
+		}
+		return tables, err
+	}
+	return series, err
+}
+
+func (p *ProxyBackend) annotations(req Request) (Response, error) {
+	a := *req.Anno()
+	upstreams := p.Upstreams
+	if u, ok := p.route(a.Annotation.Query); ok && u.Prefix != `` {
+		upstreams = []*Upstream{u}
+		a.Annotation.Query = strings.TrimPrefix(a.Annotation.Query, u.Prefix)
+	}
+	results := make([][]AnnotationResponse, len(upstreams))
+	failed, err := p.fanOut(upstreams, func(i int, u *Upstream) error {
+		return p.post(RequestContext(req), u, ReqAnnotation, &a, &results[i])
+	})
+	if err != nil && failed == len(upstreams) {
+		return InvalidData{}, err
+	}
+	var resp AnnotationsResponse
+	for _, r := range results {
+		for _, anno := range r {
+			anno.Annotation = req.Anno().Annotation
+			resp.Data = append(resp.Data, anno)
+		}
+	}
+	return resp, err
+}
+
+func (p *ProxyBackend) tagKeys(req Request) (Response, error) {
+	results := make([][]TagKey, len(p.Upstreams))
+	failed, err := p.fanOut(p.Upstreams, func(i int, u *Upstream) error {
+		return p.post(RequestContext(req), u, ReqTagKeys, req.TagKeys(), &results[i])
+	})
+	if err != nil && failed == len(p.Upstreams) {
+		return InvalidData{}, err
+	}
+	seen := make(map[string]bool)
+	var resp TagKeysResp
+	for _, r := range results {
+		for _, k := range r {
+			if !seen[k.Text] {
+				seen[k.Text] = true
+				resp.Data = append(resp.Data, k)
+			}
+		}
+	}
+	return resp, err
+}
+
+func (p *ProxyBackend) tagValues(req Request) (Response, error) {
+	results := make([][]TagValue, len(p.Upstreams))
+	failed, err := p.fanOut(p.Upstreams, func(i int, u *Upstream) error {
+		return p.post(RequestContext(req), u, ReqTagValue, req.TagValues(), &results[i])
+	})
+	if err != nil && failed == len(p.Upstreams) {
+		return InvalidData{}, err
+	}
+	seen := make(map[string]bool)
+	var resp TagValuesResp
+	for _, r := range results {
+		for _, v := range r {
+			if !seen[v.Text] {
+				seen[v.Text] = true
+				resp.Data = append(resp.Data, v)
+			}
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,124 @@
+package jsonds
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newUpstream(t *testing.T, name, prefix string, responses map[string]string) *Upstream {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			writeJSONError(w, http.StatusInternalServerError, `boom`)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return &Upstream{Name: name, URL: srv.URL, Prefix: prefix}
+}
+
+func TestProxyBackend(t *testing.T) {
+	a := newUpstream(t, `a`, `a.`, map[string]string{
+		`/search`: `["x"]`,
+		`/query`:  `[{"target": "x", "datapoints": [[1, 1000]]}]`,
+	})
+	b := newUpstream(t, `b`, `b.`, map[string]string{
+		`/search`: `["y"]`,
+		`/query`:  `[{"type": "table", "columns": [{"text": "host", "type": "string"}, {"text": "v", "type": "number"}], "rows": [["h1", 2]]}]`,
+	})
+	failing := newUpstream(t, `failing`, `f.`, nil)
+	tests := []struct {
+		name      string
+		upstreams []*Upstream
+		req       Request
+		want      Response
+		wantErr   string
+	}{
+		{
+			name:      `search merges and prefixes`,
+			upstreams: []*Upstream{a, b},
+			req:       &SearchRequest{},
+			want:      SearchResponse{Data: []string{`a.x`, `b.y`}},
+		},
+		{
+			name:      `search returns partial results`,
+			upstreams: []*Upstream{a, failing},
+			req:       &SearchRequest{},
+			want:      SearchResponse{Data: []string{`a.x`}},
+			wantErr:   `upstream failing: 500 Internal Server Error: boom`,
+		},
+		{
+			name:      `query fails when all upstreams fail`,
+			upstreams: []*Upstream{failing},
+			req:       &QueryRequest{Targets: []Target{{Target: `f.z`}}},
+			want:      InvalidData{},
+			wantErr:   `upstream failing`,
+		},
+		{
+			name:      `query returns partial series`,
+			upstreams: []*Upstream{a, failing},
+			req:       &QueryRequest{Targets: []Target{{Target: `a.x`}, {Target: `f.z`}}},
+			want:      TimeSeriesResponse{Data: []TimeSeriesData{{Target: `a.x`, Datapoints: []Datapoint{{1, 1000}}}}},
+			wantErr:   `upstream failing`,
+		},
+		{
+			name:      `query renames table number columns`,
+			upstreams: []*Upstream{b},
+			req:       &QueryRequest{Targets: []Target{{Target: `b.t`, Type: TargetTable}}},
+			want: TableResponse{Data: []TableData{{
+				Columns: []TagKey{{Text: `host`, Type: `string`}, {Text: `b.v`, Type: `number`}},
+				Rows:    [][]interface{}{{`h1`, 2.0}},
+				Type:    `table`,
+			}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProxyBackend(tt.upstreams...)
+			resp, err := p.BEHandler()(tt.req)
+			if tt.wantErr == `` && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != `` && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(resp, tt.want) {
+				t.Errorf("response = %+v, want %+v", resp, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyBackendMaxResponseBytes(t *testing.T) {
+	a := newUpstream(t, `a`, ``, map[string]string{`/search`: `["` + strings.Repeat(`x`, 100) + `"]`})
+	p := NewProxyBackend(a)
+	p.MaxResponseBytes = 50
+	if _, err := p.BEHandler()(&SearchRequest{}); err == nil || !strings.Contains(err.Error(), `exceeds 50 bytes`) {
+		t.Fatalf("error = %v, want the response limit exceeded", err)
+	}
+}
+
+func TestServePartialResults(t *testing.T) {
+	g := New(&Config{Name: `test`})
+	g.APISrv.Logger = zap.NewNop()
+	a := newUpstream(t, `a`, `a.`, map[string]string{`/search`: `["x"]`})
+	failing := newUpstream(t, `failing`, `f.`, nil)
+	g.beHandlers[SearchEndpoint] = NewProxyBackend(a, failing).BEHandler()
+	w := httptest.NewRecorder()
+	g.handleSearch(w, httptest.NewRequest(http.MethodPost, string(SearchEndpoint), strings.NewReader(`{"target":""}`)), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), `a.x`) {
+		t.Errorf("body = %s, want the results of upstream a", w.Body.String())
+	}
+	if warning := w.Header().Get(`Warning`); !strings.Contains(warning, `upstream failing`) {
+		t.Errorf("Warning = %q, want the error of upstream failing", warning)
+	}
+}
@@ -1,6 +1,8 @@
 package jsonds
 
 import (
+	"context"
+	"encoding/json"
 	"strings"
 
 	"github.com/spf13/cast"
@@ -16,6 +18,8 @@ type QueryRequest struct {
 	Format        string        `json:"format"`
 	MaxDataPoints int           `json:"maxDataPoints"`
 	ScopedVars    ScopedVar     `json:"scopedVars"`
+
+	ctx context.Context
 }
 
 // GetGlobalVar returns ScopedPaired Variables by the given variable name.
@@ -43,9 +47,40 @@ func (r *QueryRequest) ListTargets() []string {
 
 // Target specifies the intended target of a request.
 type Target struct {
-	Target string                 `json:"target"`
-	Type   string                 `json:"type"`
-	Data   map[string]interface{} `json:"data"`
+	RefID      string                 `json:"refId"`
+	Target     string                 `json:"target"`
+	Type       string                 `json:"type"`
+	Hide       bool                   `json:"hide"`
+	Datasource *DatasourceRef         `json:"datasource,omitempty"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// DatasourceRef references the datasource of a Target.
+// Older Grafana versions send the datasource name, newer versions its type and uid.
+type DatasourceRef struct {
+	Type string `json:"type,omitempty"`
+	UID  string `json:"uid,omitempty"`
+	Name string `json:"-"`
+}
+
+// UnmarshalJSON provides JSON unmarshalling for a DatasourceRef from a name or a type and uid object.
+func (d *DatasourceRef) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*d = DatasourceRef{Name: name}
+		return nil
+	}
+	type ref DatasourceRef
+	return json.Unmarshal(b, (*ref)(d))
+}
+
+// MarshalJSON provides JSON marshalling for a DatasourceRef, as a name when only the name is known.
+func (d DatasourceRef) MarshalJSON() ([]byte, error) {
+	if d.Type == `` && d.UID == `` {
+		return json.Marshal(d.Name)
+	}
+	type ref DatasourceRef
+	return json.Marshal(ref(d))
 }
 
 // GetVar returns Variables by the given variable name.
@@ -208,3 +243,11 @@ func (r *QueryRequest) TagKeys() *TagKeysReq {
 func (r *QueryRequest) TagValues() *TagValuesReq {
 	return nil
 }
+
+// Context returns the context set using WithContext, or the background context.
+func (r *QueryRequest) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
@@ -0,0 +1,235 @@
+package jsonds
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxBuckets is the number of token buckets kept before idle buckets are pruned.
+// Idle buckets are also pruned every pruneInterval.
+const (
+	maxBuckets    = 10000
+	pruneInterval = time.Minute
+)
+
+// RateLimit configures a token bucket refilling Rate tokens per second up to Burst tokens.
+// A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limiter applies token bucket rate limits per client IP, per authenticated identity and per Endpoint,
+// and caps the number of concurrent BEHandler executions. Limited requests receive a 429 response.
+// A request is only admitted if none of its limits is exceeded, in which case a token is taken from each.
+// Identities are the basic auth users of requests verified with the authenticator, see SetAuthenticator.
+type Limiter struct {
+	PerClient   RateLimit
+	PerIdentity RateLimit
+	PerEndpoint RateLimit
+
+	// TrustForwardedFor uses the first X-Forwarded-For address as the client IP when set.
+	TrustForwardedFor bool
+
+	authenticate  func(*http.Request) bool
+	maxConcurrent int
+	inflight      chan struct{}
+	buckets       map[string]*tokenBucket
+	pruned        time.Time
+	lock          sync.Mutex
+}
+
+// NewLimiter returns a new Limiter allowing at most maxConcurrent BEHandler executions.
+// A zero maxConcurrent disables the concurrency cap.
+func NewLimiter(perClient, perIdentity, perEndpoint RateLimit, maxConcurrent int) *Limiter {
+	l := &Limiter{
+		PerClient:     perClient,
+		PerIdentity:   perIdentity,
+		PerEndpoint:   perEndpoint,
+		maxConcurrent: maxConcurrent,
+		buckets:       make(map[string]*tokenBucket),
+	}
+	if maxConcurrent > 0 {
+		l.inflight = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// SetAuthenticator sets the function verifying the credentials of a request before its basic auth user
+// is rate limited as an identity. Without an authenticator no identity limits apply.
+// Configure sets it to the BasicAuth of the GrafanaBackend.
+func (l *Limiter) SetAuthenticator(authenticate func(*http.Request) bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.authenticate = authenticate
+}
+
+// MaxConcurrent returns the maximum number of concurrent BEHandler executions.
+func (l *Limiter) MaxConcurrent() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.maxConcurrent
+}
+
+// Update replaces the limits while serving requests, resetting all token buckets.
+// A changed concurrency cap applies to requests started after the update.
+func (l *Limiter) Update(perClient, perIdentity, perEndpoint RateLimit, maxConcurrent int, trustForwardedFor bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.PerClient = perClient
+	l.PerIdentity = perIdentity
+	l.PerEndpoint = perEndpoint
+	l.TrustForwardedFor = trustForwardedFor
+	l.buckets = make(map[string]*tokenBucket)
+	if maxConcurrent != l.maxConcurrent {
+		l.maxConcurrent = maxConcurrent
+		l.inflight = nil
+		if maxConcurrent > 0 {
+			l.inflight = make(chan struct{}, maxConcurrent)
+		}
+	}
+}
+
+// Wrap returns a handle enforcing the limits before calling the given handle.
+func (l *Limiter) Wrap(ep Endpoint, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if wait, ok := l.allow(ep, r); !ok {
+			writeTooManyRequests(w, wait, `rate limit exceeded`)
+			return
+		}
+		l.lock.Lock()
+		inflight := l.inflight
+		l.lock.Unlock()
+		if inflight != nil {
+			select {
+			case inflight <- struct{}{}:
+				defer func() { <-inflight }()
+			default:
+				writeTooManyRequests(w, time.Second, `too many concurrent requests`)
+				return
+			}
+		}
+		handle(w, r, p)
+	}
+}
+
+func (l *Limiter) allow(ep Endpoint, r *http.Request) (time.Duration, bool) {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.buckets) > maxBuckets || now.Sub(l.pruned) >= pruneInterval {
+		l.prune(now)
+	}
+	keys := map[string]RateLimit{
+		`endpoint:` + string(ep):  l.PerEndpoint,
+		`client:` + l.clientIP(r): l.PerClient,
+	}
+	if id := l.identity(r); id != `` {
+		keys[`identity:`+id] = l.PerIdentity
+	}
+	buckets := make([]*tokenBucket, 0, len(keys))
+	var wait time.Duration
+	for key, limit := range keys {
+		if limit.Rate <= 0 {
+			continue
+		}
+		b, ok := l.buckets[key]
+		if !ok {
+			b = newTokenBucket(limit, now)
+			l.buckets[key] = b
+		}
+		if w := b.wait(now); w > wait {
+			wait = w
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return wait, false
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0, true
+}
+
+// prune removes the buckets idle for longer than they take to refill, which are equivalent to new buckets.
+func (l *Limiter) prune(now time.Time) {
+	for k, b := range l.buckets {
+		if b.idle(now) {
+			delete(l.buckets, k)
+		}
+	}
+	l.pruned = now
+}
+
+func (l *Limiter) clientIP(r *http.Request) string {
+	if l.TrustForwardedFor {
+		if fwd := r.Header.Get(`X-Forwarded-For`); fwd != `` {
+			return strings.TrimSpace(strings.Split(fwd, `,`)[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// identity returns the basic auth user of the request, if verified by the authenticator.
+func (l *Limiter) identity(r *http.Request) string {
+	if l.authenticate == nil {
+		return ``
+	}
+	if user, _, ok := r.BasicAuth(); ok && l.authenticate(r) {
+		return user
+	}
+	return ``
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &tokenBucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// wait returns the time until a token is available, zero if one is available now.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// idle returns true if the bucket was last used longer ago than it takes to refill from empty.
+func (b *tokenBucket) idle(now time.Time) bool {
+	return now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
+
+func writeTooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	w.Header().Set(`Retry-After`, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeJSONError(w, http.StatusTooManyRequests, msg)
+}
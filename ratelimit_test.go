@@ -0,0 +1,95 @@
+package jsonds
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func limitedRequest(addr, user string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, `/query`, nil)
+	r.RemoteAddr = addr + `:1234`
+	if user != `` {
+		r.SetBasicAuth(user, `secret`)
+	}
+	return r
+}
+
+func serveLimited(l *Limiter, r *http.Request) int {
+	w := httptest.NewRecorder()
+	l.Wrap(QueryEndpoint, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {})(w, r, nil)
+	return w.Code
+}
+
+func TestLimiterRejectionTakesNoTokens(t *testing.T) {
+	l := NewLimiter(RateLimit{Rate: 0.001, Burst: 1}, RateLimit{}, RateLimit{Rate: 0.001, Burst: 2}, 0)
+	tests := []struct {
+		addr string
+		want int
+	}{
+		{`10.0.0.1`, http.StatusOK},
+		{`10.0.0.1`, http.StatusTooManyRequests},
+		{`10.0.0.1`, http.StatusTooManyRequests},
+		{`10.0.0.2`, http.StatusOK},
+		{`10.0.0.3`, http.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		if got := serveLimited(l, limitedRequest(tt.addr, ``)); got != tt.want {
+			t.Errorf("request %d from %v: status = %d, want %d", i, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterIdentity(t *testing.T) {
+	l := NewLimiter(RateLimit{}, RateLimit{Rate: 0.001, Burst: 1}, RateLimit{}, 0)
+	if got := serveLimited(l, limitedRequest(`10.0.0.1`, `alice`)); got != http.StatusOK {
+		t.Fatalf("status = %d, want %d", got, http.StatusOK)
+	}
+	if got := serveLimited(l, limitedRequest(`10.0.0.1`, `alice`)); got != http.StatusOK {
+		t.Errorf("unverified identity was rate limited: status = %d", got)
+	}
+	l.SetAuthenticator(NewBasicAuth(map[string]string{`alice`: `secret`}).Authenticate)
+	tests := []struct {
+		user string
+		want int
+	}{
+		{`alice`, http.StatusOK},
+		{`alice`, http.StatusTooManyRequests},
+		{`mallory`, http.StatusOK},
+		{`mallory`, http.StatusOK},
+	}
+	for i, tt := range tests {
+		if got := serveLimited(l, limitedRequest(`10.0.0.1`, tt.user)); got != tt.want {
+			t.Errorf("request %d as %v: status = %d, want %d", i, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterPrune(t *testing.T) {
+	l := NewLimiter(RateLimit{Rate: 1, Burst: 10}, RateLimit{}, RateLimit{}, 0)
+	now := time.Now()
+	l.pruned = now
+	for i := 0; i < 5; i++ {
+		l.allow(QueryEndpoint, limitedRequest(fmt.Sprintf("10.0.0.%d", i), ``))
+	}
+	if len(l.buckets) != 5 {
+		t.Fatalf("%d buckets, want 5", len(l.buckets))
+	}
+	tests := []struct {
+		after time.Duration
+		want  int
+	}{
+		{5 * time.Second, 5},
+		{11 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		l.prune(now.Add(tt.after))
+		if len(l.buckets) != tt.want {
+			t.Errorf("%d buckets after %v idle, want %d", len(l.buckets), tt.after, tt.want)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package jsonds
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+)
+
+// maxRecordedBody is the number of request and response body bytes kept in a Recording.
+// Larger bodies are passed through unchanged but truncated in the Recording.
+const maxRecordedBody = 10 << 20
+
+// secretHeaders are omitted from Recordings.
+var secretHeaders = [...]string{
+	`Authorization`,
+	`Proxy-Authorization`,
+	`Cookie`,
+	`Set-Cookie`,
+	`X-Api-Key`,
+	`X-Grafana-Api-Key`,
+}
+
+// Recording is a recorded request and its response, stored as a line of JSONL.
+type Recording struct {
+	Time       time.Time   `json:"time"`
+	Endpoint   Endpoint    `json:"endpoint"`
+	Method     string      `json:"method"`
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body"`
+	Status     int         `json:"status"`
+	Response   string      `json:"response"`
+	DurationMS float64     `json:"durationMs"`
+	// BodyTruncated and ResponseTruncated are set when the body or response exceeded maxRecordedBody.
+	BodyTruncated     bool `json:"bodyTruncated,omitempty"`
+	ResponseTruncated bool `json:"responseTruncated,omitempty"`
+	// Panic is the panic value of Recordings dumped by a panicking BEHandler.
+	Panic string `json:"panic,omitempty"`
+}
+
+// Recorder appends Recordings of incoming requests to a JSONL file.
+// Once the file exceeds MaxBytes it is rotated, keeping at most MaxFiles previous files
+// named path.1, path.2, etc. A zero MaxBytes disables rotation.
+type Recorder struct {
+	MaxBytes int64
+	MaxFiles int
+
+	logger *zap.Logger
+	path   string
+	file   *os.File
+	size   int64
+	lock   sync.Mutex
+}
+
+// NewRecorder opens or creates the JSONL file at path for appending Recordings.
+func NewRecorder(path string, maxBytes int64, maxFiles int) (*Recorder, error) {
+	r := &Recorder{
+		MaxBytes: maxBytes,
+		MaxFiles: maxFiles,
+		logger:   zap.NewNop(),
+		path:     path,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetLogger sets the logger used for recording failures.
+func (r *Recorder) SetLogger(logger *zap.Logger) {
+	r.logger = logger
+}
+
+func (r *Recorder) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *Recorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if r.MaxFiles > 0 {
+		for i := r.MaxFiles - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+`.1`); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// Record appends the Recording to the file, rotating it if needed.
+func (r *Recorder) Record(rec Recording) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.file == nil {
+		return fmt.Errorf("recorder closed")
+	}
+	if r.MaxBytes > 0 && r.size > 0 && r.size+int64(len(b)) > r.MaxBytes {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(b)
+	r.size += int64(n)
+	return err
+}
+
+// Close closes the Recorder file.
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// Wrap returns a handle recording each request to the given handle along with its response.
+func (r *Recorder) Wrap(ep Endpoint, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		var body []byte
+		if req.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(req.Body, maxRecordedBody+1))
+			req.Body = readCloser{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		}
+		bodyTruncated := len(body) > maxRecordedBody
+		if bodyTruncated {
+			body = body[:maxRecordedBody]
+		}
+		start := time.Now()
+		rw := newResponseWriter(w, maxRecordedBody)
+		handle(rw, req, p)
+		headers := req.Header.Clone()
+		for _, h := range secretHeaders {
+			headers.Del(h)
+		}
+		err := r.Record(Recording{
+			Time:       start,
+			Endpoint:   ep,
+			Method:     req.Method,
+			Headers:    headers,
+			Body:       string(body),
+			Status:     rw.Status(),
+			Response:   rw.body.String(),
+			DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+
+			BodyTruncated:     bodyTruncated,
+			ResponseTruncated: rw.truncated,
+		})
+		if err != nil {
+			r.logger.Error("recording failure", zap.String("endpoint", string(ep)), zap.Error(err))
+		}
+	}
+}
+
+// readCloser reads from the Reader and closes the Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ReadRecordings reads all Recordings from the JSONL file at path.
+func ReadRecordings(path string) ([]Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recs []Recording
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec Recording
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return recs, fmt.Errorf("line %d: %v", line, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, scanner.Err()
+}
+
+// ReplayResult compares the replayed response of a Recording with the recorded response.
+type ReplayResult struct {
+	Recording Recording
+	Status    int
+	Response  string
+	Match     bool
+	Diff      string
+	// Skipped is set for Recordings of Endpoints not served by the GrafanaBackend, and of truncated
+	// request bodies, which are not replayed.
+	Skipped bool
+}
+
+// Replay feeds the Recordings of the JSONL file at path through the handlers of the configured GrafanaBackend
+// and compares each response with the recorded one. JSON responses are compared by value, and truncated
+// responses by their recorded prefix. Recordings of unknown Endpoints or truncated request bodies are skipped
+// and counted in the log.
+func (g *GrafanaBackend) Replay(path string) ([]ReplayResult, error) {
+	recs, err := ReadRecordings(path)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ReplayResult, 0, len(recs))
+	var skipped int
+	for _, rec := range recs {
+		handle := g.endpointHandle(rec.Endpoint)
+		if handle == nil || rec.BodyTruncated {
+			skipped++
+			results = append(results, ReplayResult{Recording: rec, Skipped: true})
+			continue
+		}
+		req := httptest.NewRequest(rec.Method, string(rec.Endpoint), strings.NewReader(rec.Body))
+		for k, v := range rec.Headers {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		handle(w, req, nil)
+		result := ReplayResult{
+			Recording: rec,
+			Status:    w.Code,
+			Response:  w.Body.String(),
+		}
+		if rec.ResponseTruncated {
+			result.Match = rec.Status == result.Status && strings.HasPrefix(result.Response, rec.Response)
+			if !result.Match {
+				result.Diff = fmt.Sprintf("- status %d, truncated response\n+ status %d", rec.Status, result.Status)
+			}
+		} else {
+			result.Match, result.Diff = diffResponses(rec.Status, rec.Response, result.Status, result.Response)
+		}
+		results = append(results, result)
+	}
+	if skipped > 0 {
+		g.Logger(`replay`).Warn("skipped recordings of unknown endpoints or truncated requests", zap.String("file", path), zap.Int("skipped", skipped))
+	}
+	return results, nil
+}
+
+// endpointHandle returns the handle serving the given Endpoint, without middleware.
+func (g *GrafanaBackend) endpointHandle(ep Endpoint) httprouter.Handle {
+	switch ep {
+	case RootEndpoint:
+		return g.statusOK
+	case SearchEndpoint:
+		return g.handleSearch
+	case QueryEndpoint:
+		return g.handleQuery
+	case AnnotationsEndpoint:
+		return g.handleAnnotations
+	case TagKeysEndpoint:
+		return g.handleTagKeys
+	case TagValuesEndpoint:
+		return g.handleTagValues
+	}
+	return nil
+}
+
+func diffResponses(expStatus int, expected string, actStatus int, actual string) (bool, string) {
+	var diff []string
+	if expStatus != actStatus {
+		diff = append(diff, fmt.Sprintf("- status %d", expStatus), fmt.Sprintf("+ status %d", actStatus))
+	}
+	var exp, act interface{}
+	if json.Unmarshal([]byte(expected), &exp) == nil && json.Unmarshal([]byte(actual), &act) == nil {
+		if reflect.DeepEqual(exp, act) {
+			return len(diff) == 0, strings.Join(diff, "\n")
+		}
+		e, _ := json.MarshalIndent(exp, ``, `  `)
+		a, _ := json.MarshalIndent(act, ``, `  `)
+		expected, actual = string(e), string(a)
+	} else if expected == actual {
+		return len(diff) == 0, strings.Join(diff, "\n")
+	}
+	expLines, actLines := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+	for i := 0; i < len(expLines) || i < len(actLines); i++ {
+		var e, a string
+		if i < len(expLines) {
+			e = expLines[i]
+		}
+		if i < len(actLines) {
+			a = actLines[i]
+		}
+		if e != a {
+			diff = append(diff, fmt.Sprintf("- %d: %s", i+1, e), fmt.Sprintf("+ %d: %s", i+1, a))
+		}
+	}
+	return false, strings.Join(diff, "\n")
+}
+
+// responseWriter wraps a http.ResponseWriter, tracking the status code and bytes written
+// and capturing up to capture bytes of the body.
+type responseWriter struct {
+	http.ResponseWriter
+	status    int
+	bytes     int
+	body      *bytes.Buffer
+	capture   int
+	truncated bool
+}
+
+func newResponseWriter(w http.ResponseWriter, capture int) *responseWriter {
+	return &responseWriter{
+		ResponseWriter: w,
+		body:           new(bytes.Buffer),
+		capture:        capture,
+	}
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if room := w.capture - w.body.Len(); len(b) > room {
+		w.body.Write(b[:room])
+		w.truncated = w.capture > 0
+	} else {
+		w.body.Write(b)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Status returns the status code written, defaulting to 200.
+func (w *responseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
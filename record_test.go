@@ -0,0 +1,106 @@
+package jsonds
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), `requests.jsonl`)
+	rec, err := NewRecorder(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := rec.Wrap(SearchEndpoint, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+	body := `{"target":"a"}`
+	req := httptest.NewRequest(http.MethodPost, string(SearchEndpoint), strings.NewReader(body))
+	req.SetBasicAuth(`alice`, `secret`)
+	w := httptest.NewRecorder()
+	handle(w, req, nil)
+	if w.Body.String() != body {
+		t.Errorf("handle read body %q, want %q", w.Body.String(), body)
+	}
+	if err := rec.Record(Recording{Endpoint: `/unknown`, Method: http.MethodPost}); err != nil {
+		t.Fatal(err)
+	}
+	rec.Close()
+	recs, err := ReadRecordings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].Body != body || recs[0].Response != body {
+		t.Fatalf("recordings = %+v", recs)
+	}
+	if recs[0].Headers.Get(`Authorization`) != `` {
+		t.Error("Authorization header recorded")
+	}
+
+	g := New(&Config{Name: `test`})
+	g.APISrv.Logger = zap.NewNop()
+	g.beHandlers[SearchEndpoint] = func(req Request) (Response, error) {
+		return SearchResponse{Data: []string{`a`}}, nil
+	}
+	results, err := g.Replay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Skipped || !results[1].Skipped {
+		t.Fatalf("replay results = %+v, want the unknown endpoint skipped", results)
+	}
+	if results[0].Match {
+		t.Error("different responses matched")
+	}
+}
+
+func TestRecorderTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), `requests.jsonl`)
+	rec, err := NewRecorder(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat(`x`, maxRecordedBody+10)
+	handle := rec.Wrap(SearchEndpoint, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		body, _ := io.ReadAll(r.Body)
+		split := min(len(body), maxRecordedBody-5)
+		w.Write(body[:split])
+		w.Write(body[split:])
+	})
+	tests := []struct {
+		body      string
+		truncated bool
+	}{
+		{`small`, false},
+		{large, true},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handle(w, httptest.NewRequest(http.MethodPost, string(SearchEndpoint), strings.NewReader(tt.body)), nil)
+		if w.Body.Len() != len(tt.body) {
+			t.Errorf("handle wrote %d bytes, want %d", w.Body.Len(), len(tt.body))
+		}
+	}
+	rec.Close()
+	recs, err := ReadRecordings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range tests {
+		r := recs[i]
+		if r.BodyTruncated != tt.truncated || r.ResponseTruncated != tt.truncated {
+			t.Errorf("recording %d truncated = %v/%v, want %v", i, r.BodyTruncated, r.ResponseTruncated, tt.truncated)
+		}
+		if len(r.Body) > maxRecordedBody || len(r.Response) > maxRecordedBody {
+			t.Errorf("recording %d kept %d/%d bytes, want at most %d", i, len(r.Body), len(r.Response), maxRecordedBody)
+		}
+	}
+}
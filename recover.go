@@ -0,0 +1,110 @@
+package jsonds
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PanicError is returned in place of a Response when a BEHandler panics.
+type PanicError struct {
+	Endpoint Endpoint
+	Value    interface{}
+	Stack    []byte
+}
+
+// Error satisfies the error interface.
+// The panic value is omitted as the error is returned to clients.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("internal error: backend handler panic for %v", e.Endpoint)
+}
+
+// handlerPanic is a panic recovered in another goroutine, raised again in the goroutine calling the BEHandler.
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// SetPanicDumpDir enables writing the Request of a panicking BEHandler to a file within dir.
+// The file holds a single Recording and can be replayed using Replay.
+func (g *GrafanaBackend) SetPanicDumpDir(dir string) {
+	g.panicDumpDir = dir
+}
+
+// Panics returns the number of BEHandler panics recovered.
+func (g *GrafanaBackend) Panics() uint64 {
+	return atomic.LoadUint64(&g.panics)
+}
+
+// recoverBEHandler returns a BEHandler which recovers panics of the given handler, returning a PanicError.
+func (g *GrafanaBackend) recoverBEHandler(ep Endpoint, handler BEHandler) BEHandler {
+	return func(req Request) (resp Response, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				atomic.AddUint64(&g.panics, 1)
+				stack := debug.Stack()
+				if p, ok := v.(*handlerPanic); ok {
+					v, stack = p.value, p.stack
+				}
+				perr := &PanicError{
+					Endpoint: ep,
+					Value:    v,
+					Stack:    stack,
+				}
+				g.APISrv.Logger.Error("backend handler panic",
+					zap.String("endpoint", string(ep)),
+					zap.Any("panic", v),
+					zap.Any("request", req),
+					zap.ByteString("stack", perr.Stack),
+				)
+				if g.panicDumpDir != `` {
+					if path, err := dumpPanic(g.panicDumpDir, ep, v, req); err != nil {
+						g.APISrv.Logger.Error("panic dump failure", zap.Error(err))
+					} else {
+						g.APISrv.Logger.Info("panic request dumped", zap.String("file", path))
+					}
+				}
+				resp, err = InvalidData{}, perr
+			}
+		}()
+		return handler(req)
+	}
+}
+
+func dumpPanic(dir string, ep Endpoint, v interface{}, req Request) (string, error) {
+	now := time.Now()
+	body, err := json.Marshal(req)
+	if err != nil {
+		return ``, err
+	}
+	resp, err := json.Marshal(errorResponse{
+		Error:   true,
+		Message: (&PanicError{Endpoint: ep}).Error(),
+	})
+	if err != nil {
+		return ``, err
+	}
+	b, err := json.Marshal(Recording{
+		Time:     now,
+		Endpoint: ep,
+		Method:   http.MethodPost,
+		Body:     string(body),
+		Status:   http.StatusInternalServerError,
+		Response: string(resp),
+		Panic:    fmt.Sprint(v),
+	})
+	if err != nil {
+		return ``, err
+	}
+	name := fmt.Sprintf("panic-%s-%d.jsonl", strings.Trim(strings.Replace(string(ep), `/`, `-`, -1), `-`), now.UnixNano())
+	path := filepath.Join(dir, name)
+	return path, os.WriteFile(path, append(b, '\n'), 0644)
+}
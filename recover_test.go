@@ -0,0 +1,69 @@
+package jsonds
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRecoverBEHandler(t *testing.T) {
+	g := New(&Config{Name: `test`})
+	g.APISrv.Logger = zap.NewNop()
+	dir := t.TempDir()
+	g.SetPanicDumpDir(dir)
+	handler := g.wrapBEHandler(QueryEndpoint, func(req Request) (Response, error) {
+		panic(`boom`)
+	})
+	g.beHandlers[QueryEndpoint] = handler
+	resolved := &QueryRequest{Targets: []Target{
+		{RefID: `A`, Target: `a`},
+		{RefID: `B`, Target: `b`, Hide: true},
+		{RefID: `C`, Target: `$A`},
+	}}
+	if _, err := handler(resolved); err == nil {
+		t.Error("panic in resolved Targets not returned as an error")
+	}
+	_, err := handler(&QueryRequest{Targets: []Target{{Target: `a`}}})
+	var perr *PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("err = %v, want a PanicError", err)
+	}
+	if g.Panics() == 0 {
+		t.Error("panic not counted")
+	}
+	dumps, err := filepath.Glob(filepath.Join(dir, `panic-*.jsonl`))
+	if err != nil || len(dumps) == 0 {
+		t.Fatalf("no panic dump written: %v", err)
+	}
+	results, err := g.Replay(dumps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != http.StatusInternalServerError {
+		t.Fatalf("replayed panic dump = %+v, want a single 500 response", results)
+	}
+	if results[0].Recording.Panic != `boom` {
+		t.Errorf("dumped panic = %q, want %q", results[0].Recording.Panic, `boom`)
+	}
+}
+
+func TestRecoverTimeoutHandler(t *testing.T) {
+	g := New(&Config{Name: `test`})
+	g.APISrv.Logger = zap.NewNop()
+	handler := g.wrapBEHandler(SearchEndpoint, timeoutHandler(func(req Request) (Response, error) {
+		panic(`boom`)
+	}, time.Second))
+	_, err := handler(&SearchRequest{Target: `a`})
+	var perr *PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("err = %v, want a PanicError", err)
+	}
+	if perr.Value != `boom` || !strings.Contains(string(perr.Stack), `TestRecoverTimeoutHandler`) {
+		t.Errorf("PanicError = %v with stack %s, want the original panic and stack", perr.Value, perr.Stack)
+	}
+}
@@ -0,0 +1,163 @@
+package jsonds
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	handlerRegistry = make(map[string]BEHandler)
+	registryLock    sync.RWMutex
+)
+
+// RegisterHandler makes a BEHandler available by name for use in the config file.
+// Registering an existing name replaces the previous BEHandler.
+func RegisterHandler(name string, handler BEHandler) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	handlerRegistry[name] = handler
+}
+
+// LookupHandler returns the BEHandler registered with the given name.
+func LookupHandler(name string) (BEHandler, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	handler, ok := handlerRegistry[name]
+	return handler, ok
+}
+
+// RegisteredHandlers returns the sorted names of all registered BEHandlers.
+func RegisteredHandlers() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(handlerRegistry))
+	for name := range handlerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// targetRoute routes Targets matching a pattern to a BEHandler.
+type targetRoute struct {
+	match   *regexp.Regexp
+	handler BEHandler
+}
+
+// routeHandler returns a BEHandler passing Search and Query Requests to the BEHandler
+// of the first route matching the target, falling back to the given default BEHandler.
+// Query Requests spanning several BEHandlers are split and their Responses merged.
+func routeHandler(def BEHandler, routes []targetRoute) BEHandler {
+	find := func(target string) int {
+		for i, r := range routes {
+			if r.match.MatchString(target) {
+				return i
+			}
+		}
+		return -1
+	}
+	handlerFor := func(i int) BEHandler {
+		if i < 0 {
+			return def
+		}
+		return routes[i].handler
+	}
+	return func(req Request) (Response, error) {
+		switch req.ReqType() {
+		case ReqSearch:
+			return handlerFor(find(req.Search().Target))(req)
+		case ReqQuery:
+			q := req.Query()
+			var order []int
+			groups := make(map[int][]Target)
+			for _, t := range q.Targets {
+				i := find(t.Target)
+				if _, ok := groups[i]; !ok {
+					order = append(order, i)
+				}
+				groups[i] = append(groups[i], t)
+			}
+			if len(order) <= 1 {
+				idx := -1
+				if len(order) == 1 {
+					idx = order[0]
+				}
+				return handlerFor(idx)(req)
+			}
+			var merged Response
+			for _, i := range order {
+				sub := *q
+				sub.Targets = groups[i]
+				resp, err := handlerFor(i)(&sub)
+				if err != nil {
+					return InvalidData{}, err
+				}
+				if merged, err = mergeResponses(merged, resp); err != nil {
+					return InvalidData{}, err
+				}
+			}
+			return merged, nil
+		}
+		return def(req)
+	}
+}
+
+// mergeResponses combines two Query Responses of the same type.
+func mergeResponses(a, b Response) (Response, error) {
+	if a == nil {
+		return b, nil
+	}
+	switch x := a.(type) {
+	case TimeSeriesResponse:
+		if y, ok := b.(TimeSeriesResponse); ok {
+			x.Data = append(x.Data, y.Data...)
+			return x, nil
+		}
+	case TableResponse:
+		if y, ok := b.(TableResponse); ok {
+			x.Data = append(x.Data, y.Data...)
+			return x, nil
+		}
+	}
+	return InvalidData{}, fmt.Errorf("cannot merge %v response with %v response", a.RespType(), b.RespType())
+}
+
+// timeoutHandler returns a BEHandler failing once the given timeout elapses.
+// The context of the Request passed to the handler is cancelled on timeout.
+// A panic of the handler before the timeout is raised again in the calling goroutine,
+// so that it is recovered along with its stack by recoverBEHandler.
+func timeoutHandler(handler BEHandler, timeout time.Duration) BEHandler {
+	return func(req Request) (Response, error) {
+		ctx, cancel := context.WithTimeout(RequestContext(req), timeout)
+		defer cancel()
+		type result struct {
+			resp     Response
+			err      error
+			panicked *handlerPanic
+		}
+		done := make(chan result, 1)
+		go func() {
+			defer func() {
+				if v := recover(); v != nil {
+					done <- result{panicked: &handlerPanic{value: v, stack: debug.Stack()}}
+				}
+			}()
+			resp, err := handler(WithContext(req, ctx))
+			done <- result{resp: resp, err: err}
+		}()
+		select {
+		case r := <-done:
+			if r.panicked != nil {
+				panic(r.panicked)
+			}
+			return r.resp, r.err
+		case <-ctx.Done():
+			return InvalidData{}, fmt.Errorf("backend handler: %v after %v", ctx.Err(), timeout)
+		}
+	}
+}
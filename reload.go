@@ -0,0 +1,234 @@
+package jsonds
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// reloadDebounce is the time waited after a config file change before reloading,
+// as editors often write a file in several steps.
+const reloadDebounce = 250 * time.Millisecond
+
+// Data returns the static data of the current Config.
+func (g *GrafanaBackend) Data() map[string]interface{} {
+	g.configLock.RLock()
+	defer g.configLock.RUnlock()
+	return g.data
+}
+
+// CurrentConfig returns the Config currently applied.
+func (g *GrafanaBackend) CurrentConfig() *Config {
+	g.configLock.RLock()
+	defer g.configLock.RUnlock()
+	return g.config
+}
+
+// Reload validates the Config and swaps in the changes which are safe to apply while serving requests:
+// log level, BEHandler bindings and timeouts, limits, auth credentials and static data.
+// Endpoints without a Handler in the Config fall back to the BEHandler set with SetSearch, SetQuery, etc.
+// Rebinding a BEHandler purges the response and incremental caches.
+// Changes requiring a restart are logged and ignored. Nothing is applied if the Config is invalid.
+// The log level can only be lowered down to the level the httpserver module was started with.
+func (g *GrafanaBackend) Reload(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	old := g.CurrentConfig()
+	logger := g.Logger(`reload`)
+	restart := func(setting string) {
+		logger.Warn("config change requires restart, ignoring", zap.String("setting", setting))
+	}
+	if config.Name != old.Name {
+		restart(`name`)
+	}
+	if config.HTTPAddress != old.HTTPAddress {
+		restart(`http.address`)
+	}
+	if !reflect.DeepEqual(config.Cache, old.Cache) {
+		restart(`cache`)
+	}
+	var level zapcore.Level
+	level.UnmarshalText([]byte(config.LogLevel))
+
+	handlers := make(map[Endpoint]BEHandler)
+	for name, ep := range endpointNames {
+		ec, oc := config.Endpoints[name], old.Endpoints[name]
+		if reflect.DeepEqual(ec, oc) {
+			continue
+		}
+		if ec.Path != oc.Path {
+			restart(`endpoints.` + name + `.path`)
+		}
+		if ep == &RootEndpoint {
+			continue
+		}
+		handler, err := ec.handler()
+		if err != nil {
+			return fmt.Errorf("endpoints.%s: %v", name, err)
+		}
+		if handler == nil {
+			handler = g.userHandlers[ep]
+		}
+		switch {
+		case handler != nil:
+			if ec.Timeout > 0 {
+				handler = timeoutHandler(handler, ec.Timeout)
+			}
+			handlers[*ep] = g.wrapBEHandler(*ep, handler)
+		case ep == &TagKeysEndpoint || ep == &TagValuesEndpoint:
+			handlers[*ep] = g.labels.BEHandler()
+		default:
+			handlers[*ep] = defaultBEHandler
+		}
+	}
+
+	if level != g.level.Level() {
+		g.level.SetLevel(level)
+		logger.Info("reloaded log level", zap.String("loglevel", level.String()))
+	}
+	if len(handlers) > 0 {
+		g.handlerLock.Lock()
+		for ep, handler := range handlers {
+			g.beHandlers[ep] = handler
+		}
+		g.handlerLock.Unlock()
+		if g.cache != nil {
+			g.cache.Purge()
+		}
+		if g.incCache != nil {
+			g.incCache.Purge()
+		}
+		for ep := range handlers {
+			logger.Info("reloaded endpoint handler", zap.String("endpoint", string(ep)))
+		}
+	}
+	if !reflect.DeepEqual(config.Limits, old.Limits) {
+		if g.limiter != nil {
+			l := config.Limits
+			g.limiter.Update(l.PerClient, l.PerIdentity, l.PerEndpoint, l.MaxConcurrent, l.TrustForwardedFor)
+			logger.Info("reloaded limits", zap.Float64("client rate", l.PerClient.Rate), zap.Float64("identity rate", l.PerIdentity.Rate), zap.Float64("endpoint rate", l.PerEndpoint.Rate), zap.Int("max concurrent", l.MaxConcurrent))
+		} else {
+			restart(`limits`)
+		}
+	}
+	if !reflect.DeepEqual(config.Auth, old.Auth) {
+		if g.auth != nil && len(config.Auth.Users) > 0 {
+			g.auth.SetUsers(config.Auth.users())
+			logger.Info("reloaded auth credentials", zap.Int("users", len(config.Auth.Users)))
+		} else {
+			restart(`auth`)
+		}
+	}
+	if !reflect.DeepEqual(config.Data, old.Data) {
+		logger.Info("reloaded static data", zap.Int("keys", len(config.Data)))
+	}
+	g.configLock.Lock()
+	g.config = config
+	g.data = config.Data
+	g.configLock.Unlock()
+	return nil
+}
+
+// WatchConfig reloads the config file whenever it changes or SIGHUP is received.
+// Environment variables with the given prefix override the file values, as with LoadConfig.
+// Invalid configs are logged and ignored. Call the returned function to stop watching.
+func (g *GrafanaBackend) WatchConfig(filePath string, envPrefix string) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Watch the directory as editors and config management often replace the file.
+	if err := watcher.Add(filepath.Dir(filePath)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	stop := make(chan struct{})
+	logger := g.Logger(`reload`)
+	reload := func(reason string) {
+		config, err := LoadConfig(filePath, envPrefix)
+		if err == nil {
+			err = g.Reload(config)
+		}
+		if err != nil {
+			logger.Error("config reload failure", zap.String("reason", reason), zap.Error(err))
+			return
+		}
+		logger.Info("config reloaded", zap.String("reason", reason), zap.String("file", filePath))
+	}
+	go func() {
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-stop:
+				return
+			case <-sighup:
+				reload(`SIGHUP`)
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == filepath.Clean(filePath) && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					debounce = time.After(reloadDebounce)
+				}
+			case <-debounce:
+				debounce = nil
+				reload(`file changed`)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Error("config watch failure", zap.Error(err))
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sighup)
+		close(stop)
+		watcher.Close()
+	}, nil
+}
+
+// levelCore filters log entries using the AtomicLevel of the GrafanaBackend.
+type levelCore struct {
+	zapcore.Core
+	level zap.AtomicLevel
+}
+
+func (g *GrafanaBackend) levelCore(core zapcore.Core) zapcore.Core {
+	return levelCore{
+		Core:  core,
+		level: g.level,
+	}
+}
+
+// Enabled satisfies the zapcore.LevelEnabler interface.
+func (c levelCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level) && c.Core.Enabled(level)
+}
+
+// With satisfies the zapcore.Core interface.
+func (c levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelCore{
+		Core:  c.Core.With(fields),
+		level: c.level,
+	}
+}
+
+// Check satisfies the zapcore.Core interface.
+func (c levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
@@ -0,0 +1,105 @@
+package jsonds
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func searchHandler(result string) BEHandler {
+	return func(req Request) (Response, error) {
+		return SearchResponse{Data: []string{result}}, nil
+	}
+}
+
+func TestReloadHandlers(t *testing.T) {
+	RegisterHandler(`reload-config`, searchHandler(`config`))
+	RegisterHandler(`reload-slow`, func(req Request) (Response, error) {
+		<-RequestContext(req).Done()
+		return InvalidData{}, RequestContext(req).Err()
+	})
+	config := &Config{Name: `test`, LogLevel: `info`, HTTPAddress: `:8080`, Endpoints: map[string]EndpointConfig{
+		`search`: {Handler: `reload-config`},
+	}}
+	g := New(config)
+	g.SetSearch(string(SearchEndpoint), searchHandler(`user`))
+	if err := g.ApplyConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	g.Configure()
+	g.APISrv.Logger = zap.NewNop()
+	search := func() string {
+		resp, err := g.beHandler(SearchEndpoint)(&SearchRequest{Target: `a`})
+		if err != nil {
+			return err.Error()
+		}
+		return resp.(SearchResponse).Data[0]
+	}
+	if got := search(); got != `config` {
+		t.Fatalf("search = %q, want the config handler", got)
+	}
+
+	timed := *config
+	timed.Endpoints = map[string]EndpointConfig{`search`: {Handler: `reload-slow`, Timeout: 10 * time.Millisecond}}
+	if err := g.Reload(&timed); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(); got == `config` || got == `user` {
+		t.Errorf("search = %q, want a timeout", got)
+	}
+
+	unset := *config
+	unset.Endpoints = nil
+	if err := g.Reload(&unset); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(); got != `user` {
+		t.Errorf("search = %q, want the user handler", got)
+	}
+}
+
+func TestReloadPurgesIncrementalCache(t *testing.T) {
+	constant := func(v float64) BEHandler {
+		return func(req Request) (Response, error) {
+			q := req.Query()
+			series := TimeSeriesData{Target: q.Targets[0].Target}
+			for ts := q.Range.From; !ts.After(q.Range.To); ts = ts.Add(time.Minute) {
+				series.AddDataPoint(v, toMS(ts))
+			}
+			return TimeSeriesResponse{Data: []TimeSeriesData{series}}, nil
+		}
+	}
+	RegisterHandler(`reload-query-old`, constant(1))
+	RegisterHandler(`reload-query-new`, constant(2))
+	config := &Config{Name: `test`, LogLevel: `info`, HTTPAddress: `:8080`, Endpoints: map[string]EndpointConfig{
+		`query`: {Handler: `reload-query-old`},
+	}}
+	g := New(config)
+	if err := g.ApplyConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	g.SetIncrementalCache(NewIncrementalCache(0, 0))
+	g.Configure()
+	g.APISrv.Logger = zap.NewNop()
+	from := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	query := func(from time.Time) []Datapoint {
+		resp, err := g.beHandler(QueryEndpoint)(&QueryRequest{Range: Range{From: from, To: from.Add(10 * time.Minute)}, Targets: []Target{{Target: `a`}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.(TimeSeriesResponse).Data[0].Datapoints
+	}
+	query(from)
+
+	reloaded := *config
+	reloaded.Endpoints = map[string]EndpointConfig{`query`: {Handler: `reload-query-new`}}
+	if err := g.Reload(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+	for _, dp := range query(from.Add(2 * time.Minute)) {
+		if dp.MetricValue != 2 {
+			t.Fatalf("datapoint %v stitched from the old handler, want the incremental cache purged", dp)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package jsonds
 
+import "context"
+
 // RequestType identifies the target Endpoint in the request.
 type RequestType string
 
@@ -34,3 +36,39 @@ type Request interface {
 	// TagValues returns the TagValuesReq
 	TagValues() *TagValuesReq
 }
+
+// WithContext returns a shallow copy of the Request carrying the given context,
+// which BEHandlers can retrieve using RequestContext for cancellation and tracing.
+// TagKeysReq and Request types not defined by this package are returned unchanged.
+func WithContext(req Request, ctx context.Context) Request {
+	switch r := req.(type) {
+	case *QueryRequest:
+		c := *r
+		c.ctx = ctx
+		return &c
+	case *SearchRequest:
+		c := *r
+		c.ctx = ctx
+		return &c
+	case *AnnotationsReq:
+		c := *r
+		c.ctx = ctx
+		return &c
+	case *TagValuesReq:
+		c := *r
+		c.ctx = ctx
+		return &c
+	}
+	return req
+}
+
+// RequestContext returns the context of the Request if it has a Context method,
+// otherwise the background context.
+func RequestContext(req Request) context.Context {
+	if r, ok := req.(interface{ Context() context.Context }); ok {
+		if ctx := r.Context(); ctx != nil {
+			return ctx
+		}
+	}
+	return context.Background()
+}
@@ -1,15 +1,85 @@
 package jsonds
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cast"
+)
 
 // SearchRequest is used for parsing Grafana requests for variable names.
+// The Target is either plain text, or a JSON object parsed by ParseSearch.
 type SearchRequest struct {
 	Target string `json:"target"`
+
+	ctx context.Context
+	// object is set when the Target was decoded from a JSON object rather than a string.
+	object bool
+}
+
+// UnmarshalJSON provides JSON unmarshalling for a SearchRequest, accepting a JSON object target
+// which is kept as its JSON text.
+func (r *SearchRequest) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Target json.RawMessage `json:"target"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	r.Target, r.object = ``, false
+	if len(raw.Target) == 0 || string(raw.Target) == `null` {
+		return nil
+	}
+	if raw.Target[0] == '{' {
+		r.Target, r.object = string(raw.Target), true
+		return nil
+	}
+	return json.Unmarshal(raw.Target, &r.Target)
+}
+
+// MarshalJSON provides JSON marshalling for a SearchRequest, keeping a JSON object target an object.
+func (r SearchRequest) MarshalJSON() ([]byte, error) {
+	if r.object && json.Valid([]byte(r.Target)) {
+		return json.Marshal(struct {
+			Target json.RawMessage `json:"target"`
+		}{json.RawMessage(r.Target)})
+	}
+	return json.Marshal(struct {
+		Target string `json:"target"`
+	}{r.Target})
+}
+
+// IsObject returns true if the Target was sent as a JSON object.
+func (r *SearchRequest) IsObject() bool {
+	return r.object
+}
+
+// SearchValue is a text/value pair returned from a SearchRequest.
+type SearchValue struct {
+	Text  string      `json:"text"`
+	Value interface{} `json:"value"`
 }
 
 // SearchResponse contains the values returned from a SearchRequest.
+// When Values are set, the response is a list of text/value pairs, including Data as pairs of equal text and value.
 type SearchResponse struct {
-	Data []string
+	Data   []string
+	Values []SearchValue
+}
+
+// Add adds a text/value pair to the SearchResponse.
+func (r *SearchResponse) Add(text string, value interface{}) {
+	r.Values = append(r.Values, SearchValue{Text: text, Value: value})
+}
+
+// Len returns the number of values of the SearchResponse.
+func (r SearchResponse) Len() int {
+	return len(r.Data) + len(r.Values)
 }
 
 // RespType satisfies the QueryResponse interface and returns the response type.
@@ -19,7 +89,233 @@ func (r SearchResponse) RespType() ResponseType {
 
 // MarshalJSON provides JSON marshalling for a TimeSeriesResponse.
 func (r SearchResponse) MarshalJSON() ([]byte, error) {
-	return json.Marshal(r.Data)
+	if len(r.Values) == 0 {
+		return json.Marshal(r.Data)
+	}
+	values := make([]SearchValue, 0, r.Len())
+	for _, d := range r.Data {
+		values = append(values, SearchValue{Text: d, Value: d})
+	}
+	return json.Marshal(append(values, r.Values...))
+}
+
+// UnmarshalJSON provides JSON unmarshalling for a SearchResponse from strings or text/value pairs.
+func (r *SearchResponse) UnmarshalJSON(b []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(b, &items); err != nil {
+		return err
+	}
+	*r = SearchResponse{}
+	for _, item := range items {
+		var v SearchValue
+		if err := json.Unmarshal(item, &v); err == nil {
+			r.Values = append(r.Values, v)
+			continue
+		}
+		var x interface{}
+		if err := json.Unmarshal(item, &x); err != nil {
+			return err
+		}
+		r.Data = append(r.Data, cast.ToString(x))
+	}
+	return nil
+}
+
+// SearchQuery is a parsed SearchRequest Target.
+//
+// Plain text targets match values containing the text, and /regex/ targets values matching the regex.
+// JSON object targets, such as {"find":"topics","cluster":"$cluster"}, set the following fields,
+// keeping the remaining keys as Params:
+//
+//	find        what to list, selecting the SearchFunc of a Searcher
+//	text        only values containing the text
+//	regex       only values matching the regex
+//	prefix      only values starting with the prefix
+//	tree        browse values as a tree: list the children of prefix, or the roots
+//	separator   the separator of tree nodes, defaults to .
+//	limit       the maximum number of values
+type SearchQuery struct {
+	Find      string            `json:"find"`
+	Text      string            `json:"text"`
+	Regex     string            `json:"regex"`
+	Prefix    string            `json:"prefix"`
+	Tree      bool              `json:"tree"`
+	Separator string            `json:"separator"`
+	Limit     int               `json:"limit"`
+	Params    map[string]string `json:"-"`
+
+	regex *regexp.Regexp
+}
+
+// searchQueryKeys are the JSON keys of SearchQuery fields, excluded from Params.
+var searchQueryKeys = map[string]bool{
+	`find`: true, `text`: true, `regex`: true, `prefix`: true, `tree`: true, `separator`: true, `limit`: true,
+}
+
+// ParseSearch parses the Target of the SearchRequest into a SearchQuery.
+// Text targets starting with { are parsed as JSON objects too, as Grafana sends templated variable queries
+// as strings, falling back to text when they are not valid JSON, e.g. the Graphite glob {a,b}.*.
+func (r *SearchRequest) ParseSearch() (*SearchQuery, error) {
+	target := strings.TrimSpace(r.Target)
+	q := &SearchQuery{
+		Params: make(map[string]string),
+	}
+	switch {
+	case r.object || strings.HasPrefix(target, `{`):
+		if err := q.decode(target); err != nil {
+			if r.object {
+				return nil, fmt.Errorf("invalid search target: %v", err)
+			}
+			*q = SearchQuery{Text: target, Params: make(map[string]string)}
+		}
+	case len(target) > 1 && strings.HasPrefix(target, `/`) && strings.HasSuffix(target, `/`):
+		q.Regex = target[1 : len(target)-1]
+	default:
+		q.Text = target
+	}
+	if q.Separator == `` {
+		q.Separator = `.`
+	}
+	if q.Regex != `` {
+		var err error
+		if q.regex, err = regexp.Compile(q.Regex); err != nil {
+			return nil, fmt.Errorf("invalid search regex: %v", err)
+		}
+	}
+	return q, nil
+}
+
+// decode decodes the JSON object target into the SearchQuery fields and Params.
+func (q *SearchQuery) decode(target string) error {
+	if err := json.Unmarshal([]byte(target), q); err != nil {
+		return err
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(target), &params); err != nil {
+		return err
+	}
+	for k, v := range params {
+		if !searchQueryKeys[k] {
+			q.Params[k] = cast.ToString(v)
+		}
+	}
+	return nil
+}
+
+// Decode decodes the JSON object Target of the SearchRequest into v.
+func (r *SearchRequest) Decode(v interface{}) error {
+	if err := json.Unmarshal([]byte(r.Target), v); err != nil {
+		return fmt.Errorf("invalid search target: %v", err)
+	}
+	return nil
+}
+
+// Match returns true if the text matches the text, regex and prefix of the SearchQuery.
+func (q *SearchQuery) Match(text string) bool {
+	return strings.Contains(text, q.Text) &&
+		strings.HasPrefix(text, q.Prefix) &&
+		(q.regex == nil || q.regex.MatchString(text))
+}
+
+// Apply filters the values by their text, browses them as a tree when requested and applies the limit.
+// Tree nodes are returned with the node name as text and the path as value, sorted by path.
+func (q *SearchQuery) Apply(values []SearchValue) []SearchValue {
+	var out []SearchValue
+	if q.Tree {
+		prefix := q.Prefix
+		if prefix != `` && !strings.HasSuffix(prefix, q.Separator) {
+			prefix += q.Separator
+		}
+		seen := make(map[string]bool)
+		for _, v := range values {
+			path := cast.ToString(v.Value)
+			if !strings.HasPrefix(path, prefix) {
+				continue
+			}
+			node := strings.SplitN(path[len(prefix):], q.Separator, 2)[0]
+			if node == `` || seen[node] || !strings.Contains(node, q.Text) || (q.regex != nil && !q.regex.MatchString(node)) {
+				continue
+			}
+			seen[node] = true
+			out = append(out, SearchValue{Text: node, Value: prefix + node})
+		}
+		sort.Slice(out, func(i, j int) bool { return cast.ToString(out[i].Value) < cast.ToString(out[j].Value) })
+	} else {
+		for _, v := range values {
+			if q.Match(v.Text) {
+				out = append(out, v)
+			}
+		}
+	}
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out
+}
+
+// StringValues returns text/value pairs of equal text and value for the given strings.
+func StringValues(values ...string) []SearchValue {
+	out := make([]SearchValue, len(values))
+	for i, v := range values {
+		out[i] = SearchValue{Text: v, Value: v}
+	}
+	return out
+}
+
+// SearchFunc returns the values searched by the SearchQuery, which are then filtered and limited by the Searcher.
+type SearchFunc func(req Request, q *SearchQuery) ([]SearchValue, error)
+
+// Searcher answers Search Requests using the SearchFunc registered for the find of their SearchQuery.
+// Plain text and regex targets, and JSON targets without find, use the SearchFunc registered for the empty find.
+type Searcher struct {
+	funcs map[string]SearchFunc
+	lock  sync.RWMutex
+}
+
+// NewSearcher returns a new Searcher.
+func NewSearcher() *Searcher {
+	return &Searcher{
+		funcs: make(map[string]SearchFunc),
+	}
+}
+
+// Handle registers the SearchFunc for the given find.
+func (s *Searcher) Handle(find string, fn SearchFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.funcs[find] = fn
+}
+
+// HandleStrings registers a SearchFunc returning the given fixed values for the given find.
+func (s *Searcher) HandleStrings(find string, values ...string) {
+	sv := StringValues(values...)
+	s.Handle(find, func(Request, *SearchQuery) ([]SearchValue, error) {
+		return sv, nil
+	})
+}
+
+// BEHandler returns a BEHandler answering Search Requests with text/value pairs.
+func (s *Searcher) BEHandler() BEHandler {
+	return func(req Request) (Response, error) {
+		if req.ReqType() != ReqSearch {
+			return defaultBEHandler(req)
+		}
+		q, err := req.Search().ParseSearch()
+		if err != nil {
+			return InvalidData{}, err
+		}
+		s.lock.RLock()
+		fn, ok := s.funcs[q.Find]
+		s.lock.RUnlock()
+		if !ok {
+			return InvalidData{}, fmt.Errorf("search: unknown find %q", q.Find)
+		}
+		values, err := fn(req, q)
+		if err != nil {
+			return InvalidData{}, fmt.Errorf("search: find %q: %v", q.Find, err)
+		}
+		return SearchResponse{Values: q.Apply(values)}, nil
+	}
 }
 
 // ReqType returns the Request type.
@@ -51,3 +347,11 @@ func (r *SearchRequest) TagKeys() *TagKeysReq {
 func (r *SearchRequest) TagValues() *TagValuesReq {
 	return nil
 }
+
+// Context returns the context set using WithContext, or the background context.
+func (r *SearchRequest) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
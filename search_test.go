@@ -0,0 +1,111 @@
+package jsonds
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		body    string
+		want    SearchQuery
+		wantErr bool
+	}{
+		{body: `{}`, want: SearchQuery{}},
+		{body: `{"target": "kafka"}`, want: SearchQuery{Text: `kafka`}},
+		{body: `{"target": "{a,b}.*"}`, want: SearchQuery{Text: `{a,b}.*`}},
+		{body: `{"target": "{\"find\": \"topics\", \"cluster\": \"c1\"}"}`, want: SearchQuery{Find: `topics`, Params: map[string]string{`cluster`: `c1`}}},
+		{body: `{"target": "{\"find\": 1}"}`, want: SearchQuery{Text: `{"find": 1}`}},
+		{body: `{"target": "/^a/"}`, want: SearchQuery{Regex: `^a`}},
+		{body: `{"target": "/(/"}`, wantErr: true},
+		{
+			body: `{"target": {"find": "topics", "cluster": "c1", "prefix": "a", "tree": true, "separator": "/", "limit": 2}}`,
+			want: SearchQuery{Find: `topics`, Prefix: `a`, Tree: true, Separator: `/`, Limit: 2, Params: map[string]string{`cluster`: `c1`}},
+		},
+		{body: `{"target": {"find": 1}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var req SearchRequest
+		if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+			t.Fatalf("%v: %v", tt.body, err)
+		}
+		q, err := req.ParseSearch()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%v: ParseSearch succeeded, want an error", tt.body)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.body, err)
+			continue
+		}
+		want := tt.want
+		if want.Separator == `` {
+			want.Separator = `.`
+		}
+		if want.Params == nil {
+			want.Params = map[string]string{}
+		}
+		q.regex = nil
+		if !reflect.DeepEqual(*q, want) {
+			t.Errorf("%v: ParseSearch = %+v, want %+v", tt.body, *q, want)
+		}
+	}
+}
+
+func TestSearchRequestJSON(t *testing.T) {
+	for _, body := range []string{`{"target":"{a,b}.*"}`, `{"target":{"find":"topics"}}`} {
+		var req SearchRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(&req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != body {
+			t.Errorf("Marshal = %s, want %s", b, body)
+		}
+	}
+}
+
+func TestSearcher(t *testing.T) {
+	s := NewSearcher()
+	s.HandleStrings(``, `kafka.c1.lag`, `kafka.c2.lag`, `zk.latency`)
+	s.HandleStrings(`clusters`, `c1`, `c2`, `c3`)
+	handler := s.BEHandler()
+	tests := []struct {
+		body    string
+		want    []SearchValue
+		wantErr bool
+	}{
+		{`{"target": "c1"}`, StringValues(`kafka.c1.lag`), false},
+		{`{"target": "/^zk/"}`, StringValues(`zk.latency`), false},
+		{`{"target": {"tree": true}}`, []SearchValue{{Text: `kafka`, Value: `kafka`}, {Text: `zk`, Value: `zk`}}, false},
+		{`{"target": {"tree": true, "prefix": "kafka"}}`, []SearchValue{{Text: `c1`, Value: `kafka.c1`}, {Text: `c2`, Value: `kafka.c2`}}, false},
+		{`{"target": {"find": "clusters", "limit": 2}}`, StringValues(`c1`, `c2`), false},
+		{`{"target": {"find": "topics"}}`, nil, true},
+	}
+	for _, tt := range tests {
+		var req SearchRequest
+		if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := handler(&req)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%v: search succeeded, want an error", tt.body)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.body, err)
+			continue
+		}
+		if want := (SearchResponse{Values: tt.want}); !reflect.DeepEqual(resp, want) {
+			t.Errorf("%v: response = %+v, want %+v", tt.body, resp, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package jsonds
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+)
+
+// Shutdown gracefully stops the GrafanaBackend. The httpserver module is stopped so that no new connections
+// are accepted, and requests on open connections are refused, while in-flight requests are given until
+// the context is done to complete, after which their contexts are cancelled.
+// Returns the context error if in-flight requests did not complete in time.
+func (g *GrafanaBackend) Shutdown(ctx context.Context) error {
+	g.drainLock.Lock()
+	g.draining = true
+	g.drainLock.Unlock()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- g.APISrv.Stop()
+	}()
+	done := make(chan struct{})
+	go func() {
+		g.inflight.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		g.APISrv.Logger.Warn("shutdown deadline reached, cancelling in-flight requests", zap.Error(err))
+	}
+	g.cancel()
+	select {
+	case stopErr := <-stopped:
+		if stopErr != nil && err == nil {
+			err = stopErr
+		}
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// ShutdownOnSignal calls Shutdown with the given timeout once SIGINT or SIGTERM is received.
+// The result of Shutdown is sent on the returned channel.
+func (g *GrafanaBackend) ShutdownOnSignal(timeout time.Duration) <-chan error {
+	result := make(chan error, 1)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		g.APISrv.Logger.Info("shutting down", zap.String("signal", sig.String()), zap.Duration("timeout", timeout))
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		result <- g.Shutdown(ctx)
+	}()
+	return result
+}
+
+// track refuses requests while shutting down and tracks in-flight requests,
+// cancelling their context when the GrafanaBackend shuts down.
+func (g *GrafanaBackend) track(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		g.drainLock.Lock()
+		if g.draining {
+			g.drainLock.Unlock()
+			w.Header().Set(`Connection`, `close`)
+			writeJSONError(w, http.StatusServiceUnavailable, `server shutting down`)
+			return
+		}
+		g.inflight.Add(1)
+		g.drainLock.Unlock()
+		defer g.inflight.Done()
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		stop := context.AfterFunc(g.ctx, cancel)
+		defer stop()
+		handle(w, r.WithContext(ctx), p)
+	}
+}
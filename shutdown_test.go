@@ -0,0 +1,62 @@
+package jsonds
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+)
+
+func TestShutdown(t *testing.T) {
+	g := New(&Config{Name: `test`})
+	g.APISrv.Logger = zap.NewNop()
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	handle := g.track(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		close(started)
+		<-r.Context().Done()
+		close(cancelled)
+	})
+	go handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, `/query`, nil), nil)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := g.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("in-flight request not cancelled after the shutdown deadline")
+	}
+	w := httptest.NewRecorder()
+	handle(w, httptest.NewRequest(http.MethodPost, `/query`, nil), nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status after shutdown = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	g := New(&Config{Name: `test`})
+	g.APISrv.Logger = zap.NewNop()
+	started := make(chan struct{})
+	var cancelled bool
+	handle := g.track(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		cancelled = r.Context().Err() != nil
+	})
+	go handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, `/query`, nil), nil)
+	<-started
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if cancelled {
+		t.Error("in-flight request cancelled before it completed")
+	}
+}
@@ -0,0 +1,276 @@
+package jsonds
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+var sqlMacros = regexp.MustCompile(`\$__timeGroup\(\s*([^,()]+?)\s*,\s*([^()]+?)\s*\)|\$__(timeFrom|timeTo|interval)\b`)
+
+// SQLBackend answers Search and Query Requests by running per target SQL templates against a *sql.DB.
+//
+// Templates can use the following macros, expanded using the QueryRequest:
+//
+//	$__timeFrom                 bound parameter for the start of the Range
+//	$__timeTo                   bound parameter for the end of the Range
+//	$__interval                 bound parameter for the interval in seconds
+//	$__timeGroup(column, 5m)    expression grouping the column into intervals, 5m can be $__interval
+//
+// Result sets are mapped to a TableData using the column types. A column named time, or holding time values,
+// becomes the time column. For timeserie Targets, the table is converted using TableToSeries.
+// When table and timeserie Targets are mixed, the series are returned as an additional wide table.
+type SQLBackend struct {
+	DB *sql.DB
+
+	// Placeholder returns the bind parameter placeholder for the nth (1-based) parameter.
+	// Defaults to ?, use PostgresPlaceholder for $1, $2, etc.
+	Placeholder func(n int) string
+
+	// TimeParam converts the Range times to bound parameters. Defaults to unix seconds.
+	TimeParam func(time.Time) interface{}
+
+	// TimeGroup returns the SQL expression grouping the column, holding unix seconds, into intervals.
+	TimeGroup func(column string, seconds int64) string
+
+	// TimeColumnUnit is the unit of numeric values in time columns. Defaults to seconds.
+	TimeColumnUnit time.Duration
+
+	queries map[string]string
+	lock    sync.RWMutex
+}
+
+// NewSQLBackend returns a new SQLBackend for the given *sql.DB.
+func NewSQLBackend(db *sql.DB) *SQLBackend {
+	return &SQLBackend{
+		DB: db,
+		Placeholder: func(int) string {
+			return `?`
+		},
+		TimeParam: func(t time.Time) interface{} {
+			return t.Unix()
+		},
+		TimeGroup: func(column string, seconds int64) string {
+			return fmt.Sprintf("(CAST((%s) / %d AS INTEGER) * %d)", column, seconds, seconds)
+		},
+		TimeColumnUnit: time.Second,
+		queries:        make(map[string]string),
+	}
+}
+
+// PostgresPlaceholder returns $n placeholders.
+func PostgresPlaceholder(n int) string {
+	return `$` + strconv.Itoa(n)
+}
+
+// AddQuery sets the SQL template run for the given target.
+func (b *SQLBackend) AddQuery(target, sqlTemplate string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.queries[target] = sqlTemplate
+}
+
+// Targets returns the sorted target names with a SQL template.
+func (b *SQLBackend) Targets() []string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	targets := make([]string, 0, len(b.queries))
+	for t := range b.queries {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// BEHandler returns a BEHandler answering Search and Query Requests.
+// Search returns the targets containing the SearchRequest target.
+func (b *SQLBackend) BEHandler() BEHandler {
+	return func(req Request) (Response, error) {
+		switch req.ReqType() {
+		case ReqSearch:
+			var resp SearchResponse
+			for _, t := range b.Targets() {
+				if strings.Contains(t, req.Search().Target) {
+					resp.Data = append(resp.Data, t)
+				}
+			}
+			return resp, nil
+		case ReqQuery:
+			return b.query(req)
+		}
+		return defaultBEHandler(req)
+	}
+}
+
+func (b *SQLBackend) query(req Request) (Response, error) {
+	q := req.Query()
+	var series TimeSeriesResponse
+	var tables TableResponse
+	for _, t := range q.Targets {
+		b.lock.RLock()
+		tmpl, ok := b.queries[t.Target]
+		b.lock.RUnlock()
+		if !ok {
+			return InvalidData{}, fmt.Errorf("sql backend: no query for target %q", t.Target)
+		}
+		query, args, err := b.Expand(tmpl, q)
+		if err != nil {
+			return InvalidData{}, fmt.Errorf("sql backend: target %q: %v", t.Target, err)
+		}
+		td, err := b.run(req, query, args)
+		if err != nil {
+			return InvalidData{}, fmt.Errorf("sql backend: target %q: %v", t.Target, err)
+		}
+		if t.Type == TargetTable {
+			tables.Data = append(tables.Data, td)
+			continue
+		}
+		data, err := TableToSeries(td)
+		if err != nil {
+			return InvalidData{}, fmt.Errorf("sql backend: target %q: %v", t.Target, err)
+		}
+		if len(data) == 1 && len(data[0].Labels) == 0 {
+			data[0].Target = t.Target
+		}
+		series.Data = append(series.Data, q.Process(&t, data)...)
+	}
+	if len(tables.Data) > 0 {
+		if len(series.Data) > 0 {
+			tables.Data = append(tables.Data, SeriesToWideTable(series.Data))
+		}
+		return tables, nil
+	}
+	return series, nil
+}
+
+// Expand replaces the macros of the SQL template, returning the query and its bound parameters.
+func (b *SQLBackend) Expand(tmpl string, q *QueryRequest) (string, []interface{}, error) {
+	var args []interface{}
+	var err error
+	intervalSec := q.InvervalMS / 1000
+	if intervalSec < 1 {
+		intervalSec = 1
+	}
+	query := sqlMacros.ReplaceAllStringFunc(tmpl, func(m string) string {
+		groups := sqlMacros.FindStringSubmatch(m)
+		switch groups[3] {
+		case `timeFrom`:
+			args = append(args, b.TimeParam(q.Range.From))
+		case `timeTo`:
+			args = append(args, b.TimeParam(q.Range.To))
+		case `interval`:
+			args = append(args, intervalSec)
+		default:
+			seconds := intervalSec
+			if arg := strings.Trim(groups[2], `'"`); arg != `$__interval` {
+				d, perr := time.ParseDuration(arg)
+				if perr != nil || d < time.Second {
+					err = fmt.Errorf("invalid $__timeGroup interval %q", groups[2])
+					return m
+				}
+				seconds = int64(d / time.Second)
+			}
+			return b.TimeGroup(groups[1], seconds)
+		}
+		return b.Placeholder(len(args))
+	})
+	return query, args, err
+}
+
+// run executes the query and maps the result set into a TableData.
+func (b *SQLBackend) run(req Request, query string, args []interface{}) (TableData, error) {
+	rows, err := b.DB.QueryContext(RequestContext(req), query, args...)
+	if err != nil {
+		return TableData{}, err
+	}
+	defer rows.Close()
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return TableData{}, err
+	}
+	var data [][]interface{}
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return TableData{}, err
+		}
+		for i, v := range vals {
+			if x, ok := v.([]byte); ok {
+				vals[i] = string(x)
+			}
+		}
+		data = append(data, vals)
+	}
+	if err := rows.Err(); err != nil {
+		return TableData{}, err
+	}
+	td := NewTableData(len(cols))
+	for i, c := range cols {
+		keyType := sqlKeyType(c, i, data)
+		td.InsertColumn(c.Name(), string(keyType))
+		if keyType != KeyTypeTime {
+			continue
+		}
+		for _, row := range data {
+			if row[i] == nil {
+				continue
+			}
+			switch row[i].(type) {
+			case string, time.Time:
+				if ms, err := toUnixMS(row[i]); err == nil {
+					row[i] = ms
+				}
+				continue
+			}
+			if v, err := cast.ToFloat64E(row[i]); err == nil {
+				row[i] = int64(v * float64(b.TimeColumnUnit) / float64(time.Millisecond))
+			}
+		}
+	}
+	for _, row := range data {
+		td.InsertRow(row...)
+	}
+	return td, nil
+}
+
+// sqlKeyType determines the KeyType of a column from its name, database type and values.
+func sqlKeyType(c *sql.ColumnType, i int, rows [][]interface{}) KeyType {
+	if strings.EqualFold(c.Name(), ColumnTime) {
+		return KeyTypeTime
+	}
+	dbType := strings.ToUpper(c.DatabaseTypeName())
+	switch {
+	case strings.Contains(dbType, `TIME`) || strings.Contains(dbType, `DATE`):
+		return KeyTypeTime
+	case strings.Contains(dbType, `INT`) || strings.Contains(dbType, `REAL`) || strings.Contains(dbType, `FLOA`) ||
+		strings.Contains(dbType, `DOUB`) || strings.Contains(dbType, `NUM`) || strings.Contains(dbType, `DEC`):
+		return KeyTypeNumber
+	case dbType != ``:
+		return KeyTypeString
+	}
+	for _, row := range rows {
+		switch row[i].(type) {
+		case nil:
+			continue
+		case int64, float64, int, int32, float32:
+			return KeyTypeNumber
+		case string:
+			if _, err := cast.ToFloat64E(row[i]); err == nil {
+				continue
+			}
+		}
+		return KeyTypeString
+	}
+	return KeyTypeNumber
+}
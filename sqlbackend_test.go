@@ -0,0 +1,127 @@
+package jsonds
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLBackendExpand(t *testing.T) {
+	b := NewSQLBackend(nil)
+	q := &QueryRequest{
+		Range:      Range{From: time.Unix(1000, 0), To: time.Unix(2000, 0)},
+		InvervalMS: 60000,
+	}
+	tests := []struct {
+		tmpl, query string
+		args        []interface{}
+		wantErr     bool
+	}{
+		{
+			tmpl:  `SELECT * FROM m WHERE time BETWEEN $__timeFrom AND $__timeTo`,
+			query: `SELECT * FROM m WHERE time BETWEEN ? AND ?`,
+			args:  []interface{}{int64(1000), int64(2000)},
+		},
+		{
+			tmpl:  `SELECT $__timeGroup(time, $__interval), avg(v) FROM m GROUP BY 1`,
+			query: `SELECT (CAST((time) / 60 AS INTEGER) * 60), avg(v) FROM m GROUP BY 1`,
+		},
+		{
+			tmpl:  `SELECT $__timeGroup(time, '5m') AS time, $__interval FROM m`,
+			query: `SELECT (CAST((time) / 300 AS INTEGER) * 300) AS time, ? FROM m`,
+			args:  []interface{}{int64(60)},
+		},
+		{
+			tmpl:    `SELECT $__timeGroup(time, 10ms) FROM m`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		query, args, err := b.Expand(tt.tmpl, q)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, want error %v", tt.tmpl, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if query != tt.query || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%q: Expand = %q %v, want %q %v", tt.tmpl, query, args, tt.query, tt.args)
+		}
+	}
+	b.Placeholder = PostgresPlaceholder
+	if query, _, _ := b.Expand(`$__timeFrom $__timeTo`, q); query != `$1 $2` {
+		t.Errorf("Postgres placeholders = %q", query)
+	}
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open(`sqlite`, `:memory:`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		`CREATE TABLE metrics (time INTEGER, host TEXT, value REAL)`,
+		`INSERT INTO metrics VALUES (1000, 'h1', 1), (1060, 'h1', 2), (1000, 'h2', 3), (5000, 'h1', 9)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestSQLBackendQuery(t *testing.T) {
+	b := NewSQLBackend(openTestDB(t))
+	b.AddQuery(`total`, `SELECT $__timeGroup(time, 2m) AS time, sum(value) AS value FROM metrics WHERE time BETWEEN $__timeFrom AND $__timeTo GROUP BY 1 ORDER BY 1`)
+	b.AddQuery(`by host`, `SELECT time, host, value FROM metrics WHERE time BETWEEN $__timeFrom AND $__timeTo ORDER BY time`)
+	handler := b.BEHandler()
+	rng := Range{From: time.Unix(1000, 0), To: time.Unix(2000, 0)}
+
+	resp, err := handler(&QueryRequest{Range: rng, Targets: []Target{{Target: `total`, Type: TargetTimeSerie}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TimeSeriesData{{Target: `total`, Labels: Labels{}, Datapoints: []Datapoint{{6, 960000}}}}
+	if got := resp.(TimeSeriesResponse).Data; !reflect.DeepEqual(got, want) {
+		t.Errorf("total = %+v, want %+v", got, want)
+	}
+
+	resp, err = handler(&QueryRequest{Range: rng, Targets: []Target{{Target: `by host`, Type: TargetTimeSerie}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []TimeSeriesData{
+		{Target: `value {host="h1"}`, Labels: Labels{`host`: `h1`}, Datapoints: []Datapoint{{1, 1000000}, {2, 1060000}}},
+		{Target: `value {host="h2"}`, Labels: Labels{`host`: `h2`}, Datapoints: []Datapoint{{3, 1000000}}},
+	}
+	if got := resp.(TimeSeriesResponse).Data; !reflect.DeepEqual(got, want) {
+		t.Errorf("by host = %+v, want %+v", got, want)
+	}
+
+	resp, err = handler(&QueryRequest{Range: rng, Targets: []Target{{Target: `by host`, Type: TargetTable}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	td := resp.(TableResponse).Data[0]
+	wantCols := []TagKey{{Type: `time`, Text: `time`}, {Type: `string`, Text: `host`}, {Type: `number`, Text: `value`}}
+	if !reflect.DeepEqual(td.Columns, wantCols) || len(td.Rows) != 3 || td.Rows[0][0] != int64(1000000) {
+		t.Errorf("table = %+v", td)
+	}
+
+	if _, err := handler(&QueryRequest{Range: rng, Targets: []Target{{Target: `unknown`}}}); err == nil {
+		t.Error("unknown target did not fail")
+	}
+	resp, err = handler(&SearchRequest{Target: `host`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.(SearchResponse).Data; !reflect.DeepEqual(got, []string{`by host`}) {
+		t.Errorf("search = %v", got)
+	}
+}
@@ -0,0 +1,446 @@
+package jsonds
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// WriteEndpoint - (POST), used for writing to a MemoryStore (optional).
+var WriteEndpoint Endpoint = `/write`
+
+// defaultMaxWriteBytes is the default MaxWriteBytes of a MemoryStore.
+const defaultMaxWriteBytes = 32 << 20
+
+// MemoryStore is an in-memory time series store keeping Datapoints for the Retention period.
+// Series are identified by their metric name and Labels. A zero Retention keeps all Datapoints.
+type MemoryStore struct {
+	Retention time.Duration
+	// MaxWriteBytes limits the size of request bodies of the WriteHandle. A zero MaxWriteBytes disables the limit.
+	MaxWriteBytes int64
+
+	series map[string]*TimeSeriesData
+	lock   sync.RWMutex
+}
+
+// NewMemoryStore returns a new MemoryStore.
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{
+		Retention:     retention,
+		MaxWriteBytes: defaultMaxWriteBytes,
+		series:        make(map[string]*TimeSeriesData),
+	}
+}
+
+func seriesKey(metric string, labels Labels) string {
+	return metric + labels.String()
+}
+
+// Write stores a Datapoint for the metric and Labels, replacing any Datapoint with the same timestamp.
+func (s *MemoryStore) Write(metric string, labels Labels, value float64, timestampMS int64) {
+	key := seriesKey(metric, labels)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ts, ok := s.series[key]
+	if !ok {
+		ts = &TimeSeriesData{Target: metric, Labels: labels.Copy()}
+		s.series[key] = ts
+	}
+	dps := ts.Datapoints
+	i := sort.Search(len(dps), func(i int) bool { return dps[i].UnixTimestampMS >= timestampMS })
+	dp := Datapoint{MetricValue: value, UnixTimestampMS: timestampMS}
+	switch {
+	case i < len(dps) && dps[i].UnixTimestampMS == timestampMS:
+		dps[i] = dp
+	case i == len(dps):
+		ts.Datapoints = append(dps, dp)
+	default:
+		dps = append(dps, Datapoint{})
+		copy(dps[i+1:], dps[i:])
+		dps[i] = dp
+		ts.Datapoints = dps
+	}
+}
+
+// Prune drops the Datapoints older than the Retention period and the series left empty.
+func (s *MemoryStore) Prune() {
+	if s.Retention <= 0 {
+		return
+	}
+	cutoff := toMS(time.Now().Add(-s.Retention))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, ts := range s.series {
+		dps := ts.Datapoints
+		i := sort.Search(len(dps), func(i int) bool { return dps[i].UnixTimestampMS >= cutoff })
+		if i == len(dps) {
+			delete(s.series, key)
+			continue
+		}
+		ts.Datapoints = append([]Datapoint(nil), dps[i:]...)
+	}
+}
+
+// Series returns copies of the stored series for the metric.
+func (s *MemoryStore) Series(metric string, fromMS, toMS int64) []TimeSeriesData {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var data []TimeSeriesData
+	for _, ts := range s.series {
+		if ts.Target != metric {
+			continue
+		}
+		dps := ts.Datapoints
+		i := sort.Search(len(dps), func(i int) bool { return dps[i].UnixTimestampMS >= fromMS })
+		j := sort.Search(len(dps), func(i int) bool { return dps[i].UnixTimestampMS > toMS })
+		name := metric
+		if len(ts.Labels) > 0 {
+			name += ts.Labels.String()
+		}
+		data = append(data, TimeSeriesData{
+			Target:     name,
+			Labels:     ts.Labels.Copy(),
+			Datapoints: append([]Datapoint(nil), dps[i:j]...),
+		})
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Target < data[j].Target })
+	return data
+}
+
+// Metrics returns the sorted metric names.
+func (s *MemoryStore) Metrics() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	seen := make(map[string]bool)
+	var metrics []string
+	for _, ts := range s.series {
+		if !seen[ts.Target] {
+			seen[ts.Target] = true
+			metrics = append(metrics, ts.Target)
+		}
+	}
+	sort.Strings(metrics)
+	return metrics
+}
+
+// labelIndex returns a LabelIndex of all stored series.
+func (s *MemoryStore) labelIndex() *LabelIndex {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	x := NewLabelIndex()
+	for _, ts := range s.series {
+		x.Add(TimeSeriesData{Labels: ts.Labels})
+	}
+	return x
+}
+
+// BEHandler returns a BEHandler answering Search, Query, TagKeys and TagValues Requests from the MemoryStore.
+// Targets are metric names; the QueryRequest AdhocFilters, legend format and grouping apply to their Labels.
+func (s *MemoryStore) BEHandler() BEHandler {
+	return func(req Request) (Response, error) {
+		switch req.ReqType() {
+		case ReqSearch:
+			var resp SearchResponse
+			for _, m := range s.Metrics() {
+				if strings.Contains(m, req.Search().Target) {
+					resp.Data = append(resp.Data, m)
+				}
+			}
+			return resp, nil
+		case ReqQuery:
+			return s.query(req.Query()), nil
+		case ReqTagKeys:
+			return s.labelIndex().TagKeys(), nil
+		case ReqTagValue:
+			return s.labelIndex().TagValues(req.TagValues().Key), nil
+		}
+		return defaultBEHandler(req)
+	}
+}
+
+// query returns the series of the Targets, as a wide table per table Target.
+// When table and timeserie Targets are mixed, the series are returned as an additional wide table.
+func (s *MemoryStore) query(q *QueryRequest) Response {
+	var series TimeSeriesResponse
+	var tables TableResponse
+	for _, t := range q.Targets {
+		data := q.Process(&t, s.Series(t.Target, toMS(q.Range.From), toMS(q.Range.To)))
+		if t.Type == TargetTable {
+			tables.Data = append(tables.Data, SeriesToWideTable(data))
+			continue
+		}
+		series.Data = append(series.Data, data...)
+	}
+	if len(tables.Data) > 0 {
+		if len(series.Data) > 0 {
+			tables.Data = append(tables.Data, SeriesToWideTable(series.Data))
+		}
+		return tables
+	}
+	return series
+}
+
+// WriteJSON stores the series of a JSON array of TimeSeriesData, using the target as the metric name.
+// Returns the number of Datapoints written.
+func (s *MemoryStore) WriteJSON(r io.Reader) (int, error) {
+	var data []TimeSeriesData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return 0, err
+	}
+	var n int
+	for _, ts := range data {
+		if ts.Target == `` {
+			return n, fmt.Errorf("series without target")
+		}
+		for _, dp := range ts.Datapoints {
+			s.Write(ts.Target, ts.Labels, dp.MetricValue, dp.UnixTimestampMS)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// WriteLineProtocol stores the points of Influx line protocol input, with timestamps in the given precision
+// (ns, us, ms or s; defaults to ns). Each numeric field becomes the metric measurement.field, tags become Labels.
+// A field named value is stored under the measurement name. String fields are ignored.
+// Returns the number of Datapoints written.
+func (s *MemoryStore) WriteLineProtocol(r io.Reader, precision string) (int, error) {
+	var unit time.Duration
+	switch precision {
+	case ``, `ns`:
+		unit = time.Nanosecond
+	case `us`, `u`:
+		unit = time.Microsecond
+	case `ms`:
+		unit = time.Millisecond
+	case `s`:
+		unit = time.Second
+	default:
+		return 0, fmt.Errorf("unknown precision %q", precision)
+	}
+	scanner := bufio.NewScanner(r)
+	var n int
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == `` || strings.HasPrefix(text, `#`) {
+			continue
+		}
+		points, err := parseLine(text, unit)
+		if err != nil {
+			return n, fmt.Errorf("line %d: %v", line, err)
+		}
+		for _, p := range points {
+			s.Write(p.metric, p.labels, p.value, p.timestampMS)
+			n++
+		}
+	}
+	return n, scanner.Err()
+}
+
+type linePoint struct {
+	metric      string
+	labels      Labels
+	value       float64
+	timestampMS int64
+}
+
+// parseLine parses a line of Influx line protocol.
+func parseLine(line string, unit time.Duration) ([]linePoint, error) {
+	sections := splitEscaped(line, ' ')
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, fmt.Errorf("invalid line protocol: %q", line)
+	}
+	head := splitEscaped(sections[0], ',')
+	measurement := unescapeLine(head[0])
+	if measurement == `` {
+		return nil, fmt.Errorf("missing measurement")
+	}
+	labels := make(Labels, len(head)-1)
+	for _, tag := range head[1:] {
+		kv := splitEscaped(tag, '=')
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		labels[unescapeLine(kv[0])] = unescapeLine(kv[1])
+	}
+	timestampMS := toMS(time.Now())
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		timestampMS = ts * int64(unit) / int64(time.Millisecond)
+	}
+	var points []linePoint
+	for _, field := range splitEscaped(sections[1], ',') {
+		kv := splitEscaped(field, '=')
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+		name, raw := unescapeLine(kv[0]), kv[1]
+		var value float64
+		switch {
+		case strings.HasPrefix(raw, `"`):
+			continue
+		case raw == `t` || raw == `T` || raw == `true` || raw == `True` || raw == `TRUE`:
+			value = 1
+		case raw == `f` || raw == `F` || raw == `false` || raw == `False` || raw == `FALSE`:
+			value = 0
+		default:
+			v, err := strconv.ParseFloat(strings.TrimRight(raw, `iu`), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid field value %q", raw)
+			}
+			value = v
+		}
+		metric := measurement
+		if name != `value` {
+			metric += `.` + name
+		}
+		points = append(points, linePoint{metric: metric, labels: labels, value: value, timestampMS: timestampMS})
+	}
+	return points, nil
+}
+
+// splitEscaped splits s by sep, ignoring backslash escaped separators and separators within double quotes.
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	var quoted bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescapeLine(s string) string {
+	return strings.NewReplacer(`\,`, `,`, `\ `, ` `, `\=`, `=`, `\"`, `"`, `\\`, `\`).Replace(s)
+}
+
+// WriteHandle returns a handle writing the request body to the MemoryStore.
+// JSON bodies (Content-Type application/json) hold an array of TimeSeriesData,
+// any other body is read as Influx line protocol with the precision query parameter.
+// Bodies larger than MaxWriteBytes are rejected with a 413 response.
+func (s *MemoryStore) WriteHandle() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if s.MaxWriteBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, s.MaxWriteBytes)
+		}
+		var n int
+		var err error
+		if strings.HasPrefix(r.Header.Get(`Content-Type`), `application/json`) {
+			n, err = s.WriteJSON(r.Body)
+		} else {
+			n, err = s.WriteLineProtocol(r.Body, r.URL.Query().Get(`precision`))
+		}
+		if err != nil {
+			status := http.StatusBadRequest
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeJSONError(w, status, fmt.Sprintf("write failure after %d points: %v", n, err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Snapshot writes all stored series as JSON to the file at path, replacing it atomically.
+func (s *MemoryStore) Snapshot(path string) error {
+	s.lock.RLock()
+	data := make([]TimeSeriesData, 0, len(s.series))
+	for _, ts := range s.series {
+		data = append(data, *ts)
+	}
+	b, err := json.Marshal(data)
+	s.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+`.tmp`)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Restore writes the series of a snapshot file into the MemoryStore. A missing file is not an error.
+func (s *MemoryStore) Restore(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := s.WriteJSON(f); err != nil {
+		return fmt.Errorf("restore %v: %v", path, err)
+	}
+	s.Prune()
+	return nil
+}
+
+// RunMaintenance prunes the MemoryStore every interval and, when snapshotPath is set, writes a snapshot.
+// Errors are passed to onError, if set. Call the returned function to stop; a final snapshot is then written.
+func (s *MemoryStore) RunMaintenance(interval time.Duration, snapshotPath string, onError func(error)) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	snapshot := func() {
+		if snapshotPath == `` {
+			return
+		}
+		if err := s.Snapshot(snapshotPath); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				snapshot()
+				return
+			case <-ticker.C:
+				s.Prune()
+				snapshot()
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
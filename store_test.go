@@ -0,0 +1,93 @@
+package jsonds
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreLineProtocol(t *testing.T) {
+	s := NewMemoryStore(0)
+	input := "# comment\ncpu,host=h1 value=1,idle=0.5 1000000000\ncpu,host=h1 value=2 2000000000\ncpu,host=h2 value=3 1000000000\n"
+	n, err := s.WriteLineProtocol(strings.NewReader(input), ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("written = %d, want 4", n)
+	}
+	if got, want := s.Metrics(), []string{`cpu`, `cpu.idle`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Metrics = %v, want %v", got, want)
+	}
+	series := s.Series(`cpu`, 0, 1500)
+	want := []TimeSeriesData{
+		{Target: `cpu{host="h1"}`, Labels: Labels{`host`: `h1`}, Datapoints: []Datapoint{{1, 1000}}},
+		{Target: `cpu{host="h2"}`, Labels: Labels{`host`: `h2`}, Datapoints: []Datapoint{{3, 1000}}},
+	}
+	if !reflect.DeepEqual(series, want) {
+		t.Fatalf("Series = %+v, want %+v", series, want)
+	}
+	series[0].Labels[`host`] = `changed`
+	if got := s.Series(`cpu`, 0, 1500)[0].Labels[`host`]; got != `h1` {
+		t.Errorf("stored labels modified through Series: host = %q", got)
+	}
+}
+
+func TestMemoryStoreQueryTypes(t *testing.T) {
+	s := NewMemoryStore(0)
+	s.Write(`cpu`, nil, 1, 1000)
+	s.Write(`mem`, nil, 2, 1000)
+	handler := s.BEHandler()
+	rng := Range{From: time.Unix(0, 0), To: time.Unix(10, 0)}
+	tests := []struct {
+		types  []string
+		want   ResponseType
+		tables int
+	}{
+		{[]string{TargetTimeSerie, TargetTimeSerie}, RespTimeSeries, 0},
+		{[]string{TargetTimeSerie, TargetTable}, RespTable, 2},
+		{[]string{TargetTable, TargetTimeSerie}, RespTable, 2},
+		{[]string{TargetTable, TargetTable}, RespTable, 2},
+	}
+	for _, tt := range tests {
+		q := &QueryRequest{Range: rng, Targets: []Target{{Target: `cpu`, Type: tt.types[0]}, {Target: `mem`, Type: tt.types[1]}}}
+		resp, err := handler(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.RespType() != tt.want {
+			t.Errorf("%v: response type = %v, want %v", tt.types, resp.RespType(), tt.want)
+			continue
+		}
+		if tables, ok := resp.(TableResponse); ok && len(tables.Data) != tt.tables {
+			t.Errorf("%v: tables = %d, want %d", tt.types, len(tables.Data), tt.tables)
+		}
+	}
+}
+
+func TestMemoryStoreWriteHandle(t *testing.T) {
+	s := NewMemoryStore(0)
+	s.MaxWriteBytes = 64
+	handle := s.WriteHandle()
+	tests := []struct {
+		body, contentType string
+		want              int
+	}{
+		{`[{"target":"cpu","datapoints":[[1,1000]]}]`, `application/json`, http.StatusNoContent},
+		{`cpu value=1 1000`, ``, http.StatusNoContent},
+		{`cpu value=`, ``, http.StatusBadRequest},
+		{strings.Repeat("cpu value=1 1000\n", 10), ``, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, string(WriteEndpoint), strings.NewReader(tt.body))
+		r.Header.Set(`Content-Type`, tt.contentType)
+		w := httptest.NewRecorder()
+		handle(w, r, nil)
+		if w.Code != tt.want {
+			t.Errorf("%q: status = %d, want %d", tt.body, w.Code, tt.want)
+		}
+	}
+}
@@ -10,6 +10,7 @@ type TableData struct {
 	Columns    []TagKey        `json:"columns"`
 	Rows       [][]interface{} `json:"rows"`
 	Type       string          `json:"type"`
+	RefID      string          `json:"refId,omitempty"`
 	columnSize int
 }
 
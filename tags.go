@@ -1,6 +1,9 @@
 package jsonds
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+)
 
 // KeyType identifies the type for a Key.
 type KeyType string
@@ -39,6 +42,8 @@ type TagPair map[TagKey]TagValue
 // TagValuesReq describes a TagValues Request.
 type TagValuesReq struct {
 	Key string `json:"key"`
+
+	ctx context.Context
 }
 
 // TagKeysResp contains the response for a TagsValuesReq.
@@ -130,3 +135,11 @@ func (r *TagValuesReq) TagKeys() *TagKeysReq {
 func (r *TagValuesReq) TagValues() *TagValuesReq {
 	return r
 }
+
+// Context returns the context set using WithContext, or the background context.
+func (r *TagValuesReq) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
@@ -0,0 +1,309 @@
+package jsonds
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TargetError is the error of a single Target of a QueryRequest.
+type TargetError struct {
+	RefID  string
+	Target string
+	Err    error
+}
+
+// Error returns the error message prefixed with the refId, or the target when the Target has no refId.
+func (e *TargetError) Error() string {
+	if e.RefID != `` {
+		return fmt.Sprintf("target %v: %v", e.RefID, e.Err)
+	}
+	return fmt.Sprintf("target %q: %v", e.Target, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *TargetError) Unwrap() error {
+	return e.Err
+}
+
+// QueryErrors are the errors of the failed Targets of a QueryRequest.
+// They are returned to Grafana keyed by refId.
+type QueryErrors []*TargetError
+
+// Error returns the errors of all failed Targets.
+func (e QueryErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, `; `)
+}
+
+// ByRefID returns the error messages keyed by refId, or by target when the Target has no refId.
+func (e QueryErrors) ByRefID() map[string]string {
+	errs := make(map[string]string, len(e))
+	for _, err := range e {
+		key := err.RefID
+		if key == `` {
+			key = err.Target
+		}
+		errs[key] = err.Err.Error()
+	}
+	return errs
+}
+
+// ResolveTargets wraps a BEHandler, resolving the Targets of Query Requests individually:
+//
+//   - hidden Targets are skipped, unless referenced by an expression
+//   - expression Targets are evaluated over the other Targets, see ExpressionVar
+//   - the results of each Target carry its refId
+//   - failed Targets are returned as QueryErrors, keyed by refId
+//
+// Each remaining Target is passed to the handler in its own QueryRequest, concurrently, and the results
+// of the successful Targets are returned along with the QueryErrors of the failed ones, so the results
+// of a query are labelled the same whether or not it has hidden or expression Targets.
+// QueryRequests without refIds, hidden or expression Targets are passed to the handler unchanged.
+// ResolveTargets is applied to the Query handler by Configure.
+func ResolveTargets(handler BEHandler) BEHandler {
+	return func(req Request) (Response, error) {
+		if req.ReqType() != ReqQuery {
+			return handler(req)
+		}
+		q := req.Query()
+		var refIDs, resolve bool
+		for _, t := range q.Targets {
+			refIDs = refIDs || t.RefID != ``
+			resolve = resolve || t.Hide || t.IsExpression()
+		}
+		switch {
+		case !refIDs && !resolve:
+			return handler(req)
+		case !resolve && len(q.Targets) == 1:
+			t := q.Targets[0]
+			resp, err := handler(req)
+			if err != nil {
+				return InvalidData{}, QueryErrors{{RefID: t.RefID, Target: t.Target, Err: err}}
+			}
+			return withRefID(resp, t.RefID), nil
+		}
+		r := &targetResolver{
+			req:       req,
+			handler:   handler,
+			responses: make([]Response, len(q.Targets)),
+			errs:      make([]error, len(q.Targets)),
+			fetched:   make([]bool, len(q.Targets)),
+			series:    make(map[int][]TimeSeriesData),
+			visiting:  make(map[int]bool),
+		}
+		r.prefetch()
+		var errs QueryErrors
+		var series TimeSeriesResponse
+		var tables TableResponse
+		for i, t := range q.Targets {
+			if t.Hide {
+				continue
+			}
+			var resp Response
+			var err error
+			if t.IsExpression() {
+				var data []TimeSeriesData
+				if data, err = r.target(i); err == nil && t.Type == TargetTable {
+					td := SeriesToWideTable(data)
+					td.RefID = t.RefID
+					resp = TableResponse{Data: []TableData{td}}
+				} else {
+					resp = TimeSeriesResponse{Data: data}
+				}
+			} else {
+				resp, err = r.query(i)
+			}
+			if err != nil {
+				errs = append(errs, &TargetError{RefID: t.RefID, Target: t.Target, Err: err})
+				continue
+			}
+			switch x := resp.(type) {
+			case TimeSeriesResponse:
+				series.Data = append(series.Data, x.Data...)
+			case TableResponse:
+				tables.Data = append(tables.Data, x.Data...)
+			default:
+				errs = append(errs, &TargetError{RefID: t.RefID, Target: t.Target, Err: fmt.Errorf("unexpected %v response", resp.RespType())})
+			}
+		}
+		var err error
+		if len(errs) > 0 {
+			if len(series.Data) == 0 && len(tables.Data) == 0 {
+				return InvalidData{}, errs
+			}
+			err = errs
+		}
+		if len(tables.Data) > 0 {
+			if len(series.Data) > 0 {
+				tables.Data = append(tables.Data, SeriesToWideTable(series.Data))
+			}
+			return tables, err
+		}
+		return series, err
+	}
+}
+
+// withRefID returns a copy of the TimeSeries or Table response with the refId set on its data.
+func withRefID(resp Response, refID string) Response {
+	if refID == `` {
+		return resp
+	}
+	switch r := resp.(type) {
+	case TimeSeriesResponse:
+		data := make([]TimeSeriesData, len(r.Data))
+		for i, d := range r.Data {
+			d.RefID = refID
+			data[i] = d
+		}
+		return TimeSeriesResponse{Data: data}
+	case TableResponse:
+		data := make([]TableData, len(r.Data))
+		for i, d := range r.Data {
+			d.RefID = refID
+			data[i] = d
+		}
+		return TableResponse{Data: data}
+	}
+	return resp
+}
+
+// targetResolver resolves the Targets of a QueryRequest, each at most once.
+type targetResolver struct {
+	req       Request
+	handler   BEHandler
+	responses []Response
+	errs      []error
+	fetched   []bool
+	series    map[int][]TimeSeriesData
+	visiting  map[int]bool
+	stack     []string
+}
+
+// prefetch queries all visible non expression Targets concurrently.
+func (r *targetResolver) prefetch() {
+	var wg sync.WaitGroup
+	var queried []int
+	for i, t := range r.req.Query().Targets {
+		if t.Hide || t.IsExpression() {
+			continue
+		}
+		queried = append(queried, i)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.responses[i], r.errs[i] = r.fetch(i)
+		}(i)
+	}
+	wg.Wait()
+	for _, i := range queried {
+		r.fetched[i] = true
+	}
+}
+
+// query returns the Response of the ith Target, fetching it if needed.
+func (r *targetResolver) query(i int) (Response, error) {
+	if !r.fetched[i] {
+		r.responses[i], r.errs[i] = r.fetch(i)
+		r.fetched[i] = true
+	}
+	return r.responses[i], r.errs[i]
+}
+
+// fetch passes the ith Target to the handler in its own QueryRequest.
+func (r *targetResolver) fetch(i int) (Response, error) {
+	sub := *r.req.Query()
+	t := sub.Targets[i]
+	t.Hide = false
+	sub.Targets = []Target{t}
+	resp, err := r.handler(&sub)
+	if err != nil {
+		return InvalidData{}, err
+	}
+	return withRefID(resp, t.RefID), nil
+}
+
+// target returns the TimeSeriesData of the ith Target, evaluating it if it is an expression.
+func (r *targetResolver) target(i int) ([]TimeSeriesData, error) {
+	if data, ok := r.series[i]; ok {
+		return data, nil
+	}
+	q := r.req.Query()
+	t := q.Targets[i]
+	name := t.RefID
+	if name == `` {
+		name = t.Target
+	}
+	if r.visiting[i] {
+		return nil, fmt.Errorf("expression cycle: %v -> %v", strings.Join(r.stack, ` -> `), name)
+	}
+	var data []TimeSeriesData
+	if !t.IsExpression() {
+		resp, err := r.query(i)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", r.refName(i), err)
+		}
+		if resp, err = ConvertResponse(TargetTimeSerie, resp); err != nil {
+			return nil, fmt.Errorf("%v: %v", r.refName(i), err)
+		}
+		ts, ok := resp.(TimeSeriesResponse)
+		if !ok {
+			return nil, fmt.Errorf("%v: unexpected %v response", r.refName(i), resp.RespType())
+		}
+		data = ts.Data
+	} else {
+		node, err := ParseExpression(t.Target)
+		if err != nil {
+			return nil, err
+		}
+		r.visiting[i] = true
+		r.stack = append(r.stack, name)
+		v, err := r.eval(node)
+		r.stack = r.stack[:len(r.stack)-1]
+		r.visiting[i] = false
+		if err != nil {
+			return nil, err
+		}
+		if v.scalar {
+			return nil, fmt.Errorf("expression %q: result is a number, not a series", t.Target)
+		}
+		data = v.series
+		if len(data) == 1 {
+			data[0].Target = name
+		}
+		data = q.Process(&t, data)
+		for j := range data {
+			data[j].RefID = t.RefID
+		}
+	}
+	r.series[i] = data
+	return data, nil
+}
+
+// resolve returns the index of the Target referenced by refId or target name.
+func (r *targetResolver) resolve(ref string) (int, bool) {
+	targets := r.req.Query().Targets
+	for i, t := range targets {
+		if t.RefID != `` && t.RefID == ref {
+			return i, true
+		}
+	}
+	for i, t := range targets {
+		if t.Target == ref {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// refName returns the name of the ith Target for error messages.
+func (r *targetResolver) refName(i int) string {
+	if t := r.req.Query().Targets[i]; t.RefID != `` {
+		return t.RefID
+	}
+	return strconv.Quote(r.req.Query().Targets[i].Target)
+}
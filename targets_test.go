@@ -0,0 +1,141 @@
+package jsonds
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestResolveTargets(t *testing.T) {
+	var lock sync.Mutex
+	var calls [][]string
+	handler := ResolveTargets(func(req Request) (Response, error) {
+		var targets []string
+		var resp TimeSeriesResponse
+		for _, t := range req.Query().Targets {
+			targets = append(targets, t.Target)
+			resp.Data = append(resp.Data, TimeSeriesData{Target: t.Target})
+		}
+		lock.Lock()
+		calls = append(calls, targets)
+		lock.Unlock()
+		if strings.Contains(targets[0], `fail`) {
+			return InvalidData{}, errors.New("failed")
+		}
+		return resp, nil
+	})
+	tests := []struct {
+		name      string
+		targets   []Target
+		wantCalls int
+		want      Response
+		wantErrs  map[string]string
+	}{
+		{
+			name:      `targets without refIds are passed unchanged`,
+			targets:   []Target{{Target: `a`}, {Target: `b`}},
+			wantCalls: 1,
+			want:      TimeSeriesResponse{Data: []TimeSeriesData{{Target: `a`}, {Target: `b`}}},
+		},
+		{
+			name:      `multiple targets carry their refIds`,
+			targets:   []Target{{RefID: `A`, Target: `a`}, {RefID: `B`, Target: `b`}},
+			wantCalls: 2,
+			want:      TimeSeriesResponse{Data: []TimeSeriesData{{Target: `a`, RefID: `A`}, {Target: `b`, RefID: `B`}}},
+		},
+		{
+			name:      `multiple target errors are keyed by refId`,
+			targets:   []Target{{RefID: `A`, Target: `a`}, {RefID: `B`, Target: `fail`}},
+			wantCalls: 2,
+			want:      TimeSeriesResponse{Data: []TimeSeriesData{{Target: `a`, RefID: `A`}}},
+			wantErrs:  map[string]string{`B`: `failed`},
+		},
+		{
+			name:      `single target carries its refId`,
+			targets:   []Target{{RefID: `A`, Target: `a`}},
+			wantCalls: 1,
+			want:      TimeSeriesResponse{Data: []TimeSeriesData{{Target: `a`, RefID: `A`}}},
+		},
+		{
+			name:      `single target error is keyed by refId`,
+			targets:   []Target{{RefID: `A`, Target: `fail`}},
+			wantCalls: 1,
+			want:      InvalidData{},
+			wantErrs:  map[string]string{`A`: `failed`},
+		},
+		{
+			name:      `hidden targets are split and skipped`,
+			targets:   []Target{{RefID: `A`, Target: `a`}, {RefID: `B`, Target: `b`}, {RefID: `C`, Target: `c`, Hide: true}},
+			wantCalls: 2,
+			want:      TimeSeriesResponse{Data: []TimeSeriesData{{Target: `a`, RefID: `A`}, {Target: `b`, RefID: `B`}}},
+		},
+		{
+			name:      `partial results are returned with the errors`,
+			targets:   []Target{{RefID: `A`, Target: `a`}, {RefID: `B`, Target: `fail`}, {RefID: `C`, Target: `c`, Hide: true}},
+			wantCalls: 2,
+			want:      TimeSeriesResponse{Data: []TimeSeriesData{{Target: `a`, RefID: `A`}}},
+			wantErrs:  map[string]string{`B`: `failed`},
+		},
+		{
+			name:      `all failed targets`,
+			targets:   []Target{{RefID: `A`, Target: `fail`}, {RefID: `B`, Target: `fail`}, {RefID: `C`, Target: `c`, Hide: true}},
+			wantCalls: 2,
+			want:      InvalidData{},
+			wantErrs:  map[string]string{`A`: `failed`, `B`: `failed`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			resp, err := handler(&QueryRequest{Targets: tt.targets})
+			var errs QueryErrors
+			if tt.wantErrs == nil && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErrs != nil && (!errors.As(err, &errs) || !reflect.DeepEqual(errs.ByRefID(), tt.wantErrs)) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErrs)
+			}
+			if len(calls) != tt.wantCalls {
+				t.Errorf("handler called with %v, want %d calls", calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(resp, tt.want) {
+				t.Errorf("response = %+v, want %+v", resp, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeQueryErrors(t *testing.T) {
+	g := New(&Config{Name: `test`})
+	g.APISrv.Logger = zap.NewNop()
+	g.beHandlers[QueryEndpoint] = ResolveTargets(func(req Request) (Response, error) {
+		if target := req.Query().Targets[0].Target; target != `a` {
+			return InvalidData{}, errors.New(target + " failed")
+		}
+		return TimeSeriesResponse{Data: []TimeSeriesData{{Target: `a`}}}, nil
+	})
+	tests := []struct {
+		body         string
+		wantStatus   int
+		wantWarnings int
+	}{
+		{`{"targets": [{"refId": "A", "target": "a"}, {"refId": "B", "target": "b"}, {"refId": "C", "target": "c"}, {"refId": "D", "target": "d", "hide": true}]}`, http.StatusOK, 2},
+		{`{"targets": [{"refId": "B", "target": "b"}, {"refId": "D", "target": "d", "hide": true}]}`, http.StatusInternalServerError, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		g.handleQuery(w, httptest.NewRequest(http.MethodPost, string(QueryEndpoint), strings.NewReader(tt.body)), nil)
+		if w.Code != tt.wantStatus {
+			t.Errorf("status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body.String())
+		}
+		if warnings := w.Header().Values(`Warning`); len(warnings) != tt.wantWarnings {
+			t.Errorf("Warning headers = %q, want %d", warnings, tt.wantWarnings)
+		}
+	}
+}
@@ -17,10 +17,23 @@ func (d Datapoint) MarshalJSON() ([]byte, error) {
 	return json.Marshal(vals)
 }
 
+// UnmarshalJSON provides JSON unmarshalling for a Datapoint from a [value, timestamp] pair.
+func (d *Datapoint) UnmarshalJSON(b []byte) error {
+	var vals [2]float64
+	if err := json.Unmarshal(b, &vals); err != nil {
+		return err
+	}
+	d.MetricValue = vals[0]
+	d.UnixTimestampMS = int64(vals[1])
+	return nil
+}
+
 // TimeSeriesData contains the datapoints for a TimeSeriesResponse.
 type TimeSeriesData struct {
 	Target     string      `json:"target"`
 	Datapoints []Datapoint `json:"datapoints"`
+	Labels     Labels      `json:"labels,omitempty"`
+	RefID      string      `json:"refId,omitempty"`
 }
 
 // AddDataPoint adds a Datapoint to a TimeSeriesData collection.
@@ -0,0 +1,104 @@
+package jsonds
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// SetTracerProvider enables tracing of requests with the given TracerProvider,
+// configured by the caller with the exporter of its choice.
+// Spans are created for the request as well as its decode, handler and encode phases.
+func (g *GrafanaBackend) SetTracerProvider(tp trace.TracerProvider) {
+	g.tracer = tp.Tracer(applicationName)
+}
+
+func noopTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(applicationName)
+}
+
+// traceRequest starts a server span for the request, continuing any W3C trace context from the request headers.
+func (g *GrafanaBackend) traceRequest(ep Endpoint, handle httprouter.Handle) httprouter.Handle {
+	propagator := propagation.TraceContext{}
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := g.tracer.Start(ctx, r.Method+` `+string(ep),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String(`http.method`, r.Method),
+				attribute.String(`endpoint`, string(ep)),
+			),
+		)
+		defer span.End()
+		rw := newResponseWriter(w, 0)
+		handle(rw, r.WithContext(ctx), p)
+		span.SetAttributes(attribute.Int(`http.status_code`, rw.Status()))
+		if rw.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.Status()))
+		}
+	}
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// requestAttributes returns the span attributes describing a Request.
+func requestAttributes(req Request) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String(`request.type`, string(req.ReqType()))}
+	switch req.ReqType() {
+	case ReqQuery:
+		q := req.Query()
+		attrs = append(attrs,
+			attribute.StringSlice(`query.targets`, q.ListTargets()),
+			attribute.Int64(`query.range_ms`, toMS(q.Range.To)-toMS(q.Range.From)),
+			attribute.Int64(`query.interval_ms`, q.InvervalMS),
+		)
+	case ReqSearch:
+		attrs = append(attrs, attribute.String(`search.target`, req.Search().Target))
+	case ReqAnnotation:
+		a := req.Anno()
+		attrs = append(attrs,
+			attribute.String(`annotation.name`, a.Annotation.Name),
+			attribute.Int64(`annotation.range_ms`, toMS(a.Range.To)-toMS(a.Range.From)),
+		)
+	case ReqTagValue:
+		attrs = append(attrs, attribute.String(`tagvalues.key`, req.TagValues().Key))
+	}
+	return attrs
+}
+
+// responseAttributes returns the span attributes describing a Response.
+func responseAttributes(resp Response) []attribute.KeyValue {
+	if resp == nil {
+		return nil
+	}
+	attrs := []attribute.KeyValue{attribute.String(`response.type`, string(resp.RespType()))}
+	switch r := resp.(type) {
+	case TimeSeriesResponse:
+		var points int
+		for _, d := range r.Data {
+			points += len(d.Datapoints)
+		}
+		attrs = append(attrs, attribute.Int(`response.series`, len(r.Data)), attribute.Int(`response.points`, points))
+	case TableResponse:
+		var rows int
+		for _, d := range r.Data {
+			rows += len(d.Rows)
+		}
+		attrs = append(attrs, attribute.Int(`response.tables`, len(r.Data)), attribute.Int(`response.rows`, rows))
+	case SearchResponse:
+		attrs = append(attrs, attribute.Int(`response.results`, r.Len()))
+	}
+	return attrs
+}
@@ -0,0 +1,52 @@
+package jsonds
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+func TestTraceRequest(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	g := New(&Config{Name: `test`})
+	g.APISrv.Logger = zap.NewNop()
+	g.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	g.beHandlers[QueryEndpoint] = func(req Request) (Response, error) {
+		return TimeSeriesResponse{Data: []TimeSeriesData{{Target: `a`, Datapoints: []Datapoint{{1, 1000}, {2, 2000}}}}}, nil
+	}
+	handle := g.traceRequest(QueryEndpoint, g.handleQuery)
+	r := httptest.NewRequest(http.MethodPost, string(QueryEndpoint), strings.NewReader(`{"targets":[{"target":"a"}]}`))
+	r.Header.Set(`traceparent`, `00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01`)
+	w := httptest.NewRecorder()
+	handle(w, r, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	spans := exporter.GetSpans()
+	names := make(map[string]tracetest.SpanStub, len(spans))
+	for _, s := range spans {
+		names[s.Name] = s
+		if s.SpanContext.TraceID().String() != `4bf92f3577b34da6a3ce929d0e0e4736` {
+			t.Errorf("span %v not part of the propagated trace", s.Name)
+		}
+	}
+	for _, name := range []string{`POST /query`, `decode`, `handler`, `encode`} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("missing span %v, got %v", name, spans)
+		}
+	}
+	var points int64
+	for _, attr := range names[`handler`].Attributes {
+		if attr.Key == `response.points` {
+			points = attr.Value.AsInt64()
+		}
+	}
+	if points != 2 {
+		t.Errorf("response.points = %d, want 2", points)
+	}
+}